@@ -2,23 +2,34 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
-	"fmt"
-	"math/big"
+	"encoding/json"
 	"strconv"
 	"time"
 )
 
-const targetBits = 3              // Mining difficulty definition
-const maxNonce = 1<<31 - 1        // Maximum value for a 32-bit integer, used as the limit for mining attempts
-const MaxTransactionsPerBlock = 5 // Assuming a maximum of 5 transactions per block
+const targetBits = 3                 // Initial mining difficulty, in leading zero bits
+const MaxTransactionsPerBlock = 1000 // Most transactions in a block, coinbase included
+const MaxBlockSize = 1 << 20         // Largest encoded block, in bytes
 
 type Block struct {
 	Timestamp     int64
 	Transactions  []*Transaction // Replacing the original Data field with a list of transactions
 	PrevBlockHash []byte
+	MerkleRoot    []byte // Merkle root over the IDs of Transactions
 	Hash          []byte
 	Nonce         int
+	Difficulty    int // Number of leading zero bits the block hash must have
+}
+
+// Size returns the length of the block's encoded form in bytes.
+func (b *Block) Size() int {
+	encoded, err := json.Marshal(b)
+	if err != nil {
+		return 0
+	}
+	return len(encoded)
 }
 
 // SetHash calculates and sets the hash of the block, without returning a value
@@ -35,61 +46,22 @@ func (b *Block) ComputeHash() []byte {
 	return hash[:]
 }
 
-// hashTransactions serializes a slice of transactions and calculates its hash
-func hashTransactions(transactions []*Transaction) []byte {
-	var txHashes [][]byte
-
-	for _, tx := range transactions {
-		serializedTx, err := tx.Serialize()
-		if err != nil {
-			panic(err) // In real applications, errors should be handled more gracefully
-		}
-		txHashes = append(txHashes, serializedTx)
-	}
-
-	txHash := sha256.Sum256(bytes.Join(txHashes, []byte{}))
-	return txHash[:] // Converts the array to a slice
-}
-
 func IntToHex(n int64) []byte {
 	return []byte(strconv.FormatInt(n, 16))
 }
 
-// MineBlock implements the mining functionality
+// MineBlock mines the block on every CPU core until it finds a valid hash
 func (b *Block) MineBlock() {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
-
-	fmt.Println("Mining a new block...")
-
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
-
-	for nonce < maxNonce {
-		data := prepareData(b, nonce)
-		hash = sha256.Sum256(data)
-		fmt.Printf("\r%x", hash)
-		hashInt.SetBytes(hash[:])
-
-		if hashInt.Cmp(target) == -1 {
-			fmt.Println("\n\nSuccess!")
-			b.Hash = hash[:]
-			b.Nonce = nonce
-			break
-		} else {
-			nonce++
-		}
-	}
+	b.Mine(context.Background(), MiningWorkers)
 }
 
 func prepareData(b *Block, nonce int) []byte {
 	return bytes.Join(
 		[][]byte{
 			b.PrevBlockHash,
-			hashTransactions(b.Transactions), // Hash of the transaction data
+			b.MerkleRoot, // Commits to the transactions without hashing them all
 			IntToHex(b.Timestamp),
-			IntToHex(int64(targetBits)),
+			IntToHex(int64(b.Difficulty)),
 			IntToHex(int64(nonce)),
 		},
 		[]byte{},
@@ -98,12 +70,17 @@ func prepareData(b *Block, nonce int) []byte {
 
 // Note, the NewBlock function no longer needs to create a genesis block, it's only for creating regular blocks
 func NewBlock(transactions []*Transaction, prevBlockHash []byte) *Block {
+	return NewBlockWithDifficulty(transactions, prevBlockHash, targetBits)
+}
+
+// NewBlockWithDifficulty creates and mines a block at the given difficulty
+func NewBlockWithDifficulty(transactions []*Transaction, prevBlockHash []byte, difficulty int) *Block {
 	// Ensure the number of transactions in the block doesn't exceed the limit
 	if len(transactions) > MaxTransactionsPerBlock {
 		transactions = transactions[:MaxTransactionsPerBlock]
 	}
 
-	block := &Block{Timestamp: time.Now().Unix(), Transactions: transactions, PrevBlockHash: prevBlockHash, Hash: []byte{}, Nonce: 0}
+	block := &Block{Timestamp: time.Now().Unix(), Transactions: transactions, PrevBlockHash: prevBlockHash, Hash: []byte{}, Nonce: 0, Difficulty: difficulty}
 	block.MineBlock() // Mine all non-genesis blocks
 	return block
 }
@@ -2,7 +2,9 @@ package main
 
 import (
 	"math/big"
+	"net/rpc"
 	"testing"
+	"time"
 )
 
 func TestSetHash(t *testing.T) {
@@ -37,3 +39,54 @@ func TestMineBlock(t *testing.T) {
 		t.Errorf("MineBlock() failed to mine a block with hash less than the target")
 	}
 }
+
+func TestValidateRejectsUnsolvedProofOfWork(t *testing.T) {
+	parent := NewBlock([]*Transaction{}, []byte{})
+	block := &Block{Timestamp: parent.Timestamp, Transactions: []*Transaction{}, PrevBlockHash: parent.Hash, Difficulty: targetBits}
+	block.MerkleRoot = block.HashTransactions()
+
+	// Find a nonce whose correctly computed hash misses the target
+	var hashInt big.Int
+	for {
+		block.SetHash()
+		hashInt.SetBytes(block.Hash)
+		if hashInt.Cmp(block.target()) >= 0 {
+			break
+		}
+		block.Nonce++
+	}
+
+	err := block.Validate(parent)
+	if validationErr := AsBlockValidationError(err); validationErr == nil || validationErr.Rule != RuleProofOfWork {
+		t.Errorf("Validate() failed, expected a %q error, got %v", RuleProofOfWork, err)
+	}
+}
+
+func TestValidateRejectsBadTimestamps(t *testing.T) {
+	parent := NewBlock([]*Transaction{}, []byte{})
+
+	early := &Block{Timestamp: parent.Timestamp - 1, Transactions: []*Transaction{}, PrevBlockHash: parent.Hash, Difficulty: targetBits}
+	early.MineBlock()
+	if err := AsBlockValidationError(early.Validate(parent)); err == nil || err.Rule != RuleTimestamp {
+		t.Error("Validate() failed, a block older than its parent should be rejected")
+	}
+
+	future := &Block{Timestamp: time.Now().Add(time.Hour).Unix(), Transactions: []*Transaction{}, PrevBlockHash: parent.Hash, Difficulty: targetBits}
+	future.MineBlock()
+	if err := AsBlockValidationError(future.Validate(parent)); err == nil || err.Rule != RuleTimestamp {
+		t.Error("Validate() failed, a block far in the future should be rejected")
+	}
+}
+
+func TestAsBlockValidationErrorFromRPC(t *testing.T) {
+	original := &BlockValidationError{Rule: RuleHash, Reason: "hash does not match"}
+
+	// net/rpc delivers errors to the caller as plain strings
+	parsed := AsBlockValidationError(rpc.ServerError(original.Error()))
+	if parsed == nil || *parsed != *original {
+		t.Errorf("AsBlockValidationError() failed, expected %v, got %v", original, parsed)
+	}
+	if AsBlockValidationError(rpc.ServerError("connection reset")) != nil {
+		t.Error("AsBlockValidationError() failed, unrelated errors should not be parsed")
+	}
+}
@@ -2,28 +2,112 @@ package main
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"time"
 )
 
 type Blockchain struct {
 	Blocks  []*Block
 	Mempool *Mempool
+	UTXOSet *UTXOSet          // Unspent outputs of Blocks, kept in step as blocks are appended
+	Nonces  map[string]uint64 // Next nonce expected from each sender
+	store   *BlockStore       // On-disk copy of Blocks, nil for an in-memory chain
+	undos   []*blockUndo      // UTXO changes made by each block, used to roll back during a reorg
+	journal *MempoolJournal   // On-disk copy of Mempool, nil for an in-memory chain
 }
 
-// NewBlockchain creates a new blockchain with the initial genesis block
-func NewBlockchain() *Blockchain {
+// NewBlockchain creates a new blockchain with the initial genesis block.
+// If dataDir is not empty the chain and the mempool are persisted there and loaded back on restart.
+// A stored chain built on another genesis block than genesis.block is discarded.
+func NewBlockchain(dataDir string) *Blockchain {
+	bc := &Blockchain{Mempool: NewMempool()}
 	genesisBlock := LoadGenesisBlock()
-	if genesisBlock == nil {
-		genesisBlock = NewGenesisBlock()
-		SaveGenesisBlock(genesisBlock)
+
+	var blocks []*Block
+	if dataDir != "" {
+		store, err := OpenBlockStore(dataDir)
+		if err != nil {
+			log.Fatalf("Failed to open block store in %s: %v", dataDir, err)
+		}
+		bc.store = store
+
+		blocks, err = store.Blocks()
+		if err != nil {
+			log.Fatalf("Failed to load blocks from %s: %v", dataDir, err)
+		}
+		if len(blocks) > 0 && genesisBlock != nil && !bytes.Equal(blocks[0].Hash, genesisBlock.Hash) {
+			// The genesis block was regenerated since, so the stored chain can no longer sync with anyone
+			log.Printf("Discarding the %d blocks stored in %s, they start from another genesis block", len(blocks), dataDir)
+			blocks = nil
+		}
+		if len(blocks) > 0 {
+			log.Printf("Loaded %d blocks from %s", len(blocks), dataDir)
+		}
+	}
+
+	if len(blocks) == 0 {
+		if genesisBlock == nil {
+			genesisBlock = NewGenesisBlock()
+			SaveGenesisBlock(genesisBlock)
+		}
+		blocks = []*Block{genesisBlock}
+	}
+
+	if err := bc.ReplaceBlocks(blocks); err != nil {
+		log.Fatalf("Stored blockchain in %s is invalid: %v", dataDir, err)
+	}
+
+	if dataDir != "" {
+		journal, err := OpenMempoolJournal(dataDir)
+		if err != nil {
+			log.Fatalf("Failed to open mempool journal in %s: %v", dataDir, err)
+		}
+		bc.restoreMempool(journal)
+	}
+	return bc
+}
+
+// restoreMempool replays the journal into the mempool, revalidating every entry against
+// the chain, and then starts recording accepted transactions in it. Transactions that
+// were mined, have expired or are no longer valid are dropped.
+func (bc *Blockchain) restoreMempool(journal *MempoolJournal) {
+	entries, err := journal.Load()
+	if err != nil {
+		log.Printf("Failed to read mempool journal: %v", err)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Tx.Nonce < entries[j].Tx.Nonce })
+	arrivals := make(map[string]time.Time)
+	for _, entry := range entries {
+		if time.Since(entry.Added) > MempoolExpiry {
+			continue
+		}
+		if bc.AddTransactionToMempool(entry.Tx) == nil {
+			arrivals[hex.EncodeToString(entry.Tx.ID)] = entry.Added
+		}
+	}
+	bc.Mempool.restoreArrivals(arrivals)
+
+	bc.journal = journal
+	bc.rewriteJournal()
+	if len(entries) > 0 {
+		log.Printf("Restored %d of %d pending transactions from the mempool journal", bc.Mempool.Len(), len(entries))
 	}
+}
 
-	return &Blockchain{
-		Blocks:  []*Block{genesisBlock},
-		Mempool: NewMempool(),
+// rewriteJournal replaces the journal with the current contents of the mempool.
+func (bc *Blockchain) rewriteJournal() {
+	if bc.journal == nil {
+		return
+	}
+	if err := bc.journal.Rewrite(bc.Mempool.journalEntries()); err != nil {
+		log.Printf("Failed to rewrite mempool journal: %v", err)
 	}
 }
 
@@ -37,9 +121,13 @@ func NewGenesisBlock() *Block {
 		log.Fatal(err)
 	}
 
+	keystore := NewKeystore(keystoreDir)
 	for i := 0; i < 5; i++ {
 		username, password := generateRandomCredentials()
 		wallet := NewWallet()
+		if err := keystore.Store(wallet, password); err != nil {
+			log.Fatal(err)
+		}
 		saveGenesisUserToFile(username, password, wallet.Address())
 
 		genesisTransactions = append(genesisTransactions, NewTransaction("", wallet.Address(), 100))
@@ -55,12 +143,35 @@ func NewGenesisTransaction() *Transaction {
 	return tx
 }
 
-// IsValid checks if the block's hash is correct
+// IsValid checks the block's hash, proof of work and timestamp
 func (b *Block) IsValid() bool {
-	if !bytes.Equal(b.Hash, b.ComputeHash()) {
-		fmt.Println("invalid hash")
+	if err := b.Validate(nil); err != nil {
+		fmt.Println(err)
+		return false
 	}
-	return bytes.Equal(b.Hash, b.ComputeHash())
+	return true
+}
+
+// HasValidTransactions checks the signature of every transaction in the block.
+// Only the genesis block and a leading coinbase may carry unsigned transactions, which mint coins.
+func (b *Block) HasValidTransactions() bool {
+	if len(b.PrevBlockHash) == 0 {
+		return true
+	}
+	for i, tx := range b.Transactions {
+		if i == 0 && tx.IsCoinbase() {
+			if tx.To == "" {
+				fmt.Printf("Coinbase %x has no receiver\n", tx.ID)
+				return false
+			}
+			continue
+		}
+		if !tx.IsValid() {
+			fmt.Printf("Transaction %x is not validly signed\n", tx.ID)
+			return false
+		}
+	}
+	return true
 }
 
 // ValidateChain validates the blockchain
@@ -69,13 +180,13 @@ func (bc *Blockchain) ValidateChain() bool {
 		currentBlock := bc.Blocks[i]
 		prevBlock := bc.Blocks[i-1]
 
-		if !bytes.Equal(currentBlock.Hash, currentBlock.ComputeHash()) {
-			fmt.Printf("Block %d has invalid hash\n", i)
+		if err := currentBlock.Validate(prevBlock); err != nil {
+			fmt.Printf("Block %d is invalid: %v\n", i, err)
 			return false
 		}
 
-		if !bytes.Equal(currentBlock.PrevBlockHash, prevBlock.Hash) {
-			fmt.Printf("Block %d points to incorrect previous hash\n", i)
+		if currentBlock.Difficulty != requiredDifficulty(bc.Blocks[:i]) {
+			fmt.Printf("Block %d has difficulty %d, expected %d\n", i, currentBlock.Difficulty, requiredDifficulty(bc.Blocks[:i]))
 			return false
 		}
 	}
@@ -83,36 +194,219 @@ func (bc *Blockchain) ValidateChain() bool {
 }
 
 // AddTransactionToMempool adds a transaction to the mempool
-func (bc *Blockchain) AddTransactionToMempool(tx *Transaction) {
-	if tx.IsValid() && bc.IsValidAddress(tx.From) && bc.IsValidAddress(tx.To) && bc.GetBalance(tx.From) >= tx.Amount {
-		bc.Mempool.AddTransaction(tx)
-	} else {
-		fmt.Println("Invalid transaction, invalid addresses or insufficient balance")
+func (bc *Blockchain) AddTransactionToMempool(tx *Transaction) error {
+	if !tx.IsValid() {
+		fmt.Println("Invalid transaction, signature does not verify")
+		return errors.New("invalid transaction signature")
+	}
+	if !bc.IsValidAddress(tx.From) || !bc.IsValidAddress(tx.To) {
+		return errors.New("invalid addresses")
+	}
+	if tx.FeeRate() < MinRelayFee {
+		return fmt.Errorf("fee rate %.2f is below the minimum relay fee %.2f", tx.FeeRate(), MinRelayFee)
+	}
+	if bc.Mempool.Contains(tx.ID) {
+		return ErrAlreadyInMempool
+	}
+	if expected := bc.Mempool.NextNonce(tx.From, bc.NextNonce(tx.From)); tx.Nonce > expected {
+		return fmt.Errorf("%w: nonce %d, expected %d", ErrNonceGap, tx.Nonce, expected)
+	} else if tx.Nonce != expected {
+		return fmt.Errorf("transaction has nonce %d, expected %d", tx.Nonce, expected)
+	}
+	// The sender's pending transactions are spent first, so together they must not overspend
+	if err := bc.UTXOSet.CheckTransactions(append(bc.Mempool.PendingFrom(tx.From), tx)); err != nil {
+		fmt.Println("Invalid transaction, insufficient balance or spent inputs")
+		return err
+	}
+	pending := bc.Mempool.Len()
+	if err := bc.Mempool.AddTransaction(tx); err != nil {
+		return err
 	}
+	if bc.Mempool.Len() != pending+1 {
+		// Adding expired or evicted other transactions, which the journal must forget too
+		bc.rewriteJournal()
+	} else if bc.journal != nil {
+		if err := bc.journal.Append(tx, time.Now()); err != nil {
+			log.Printf("Failed to record transaction %x in the mempool journal: %v", tx.ID, err)
+		}
+	}
+	return nil
 }
 
-// MineBlock mines a block from transactions in the mempool
-func (bc *Blockchain) MineBlock() {
-	lastBlock := bc.Blocks[len(bc.Blocks)-1]
-	newBlock := NewBlock(bc.Mempool.GetTransactions(), lastBlock.Hash)
-	bc.Blocks = append(bc.Blocks, newBlock)
+// ExpireMempool drops the transactions that have waited longer than MempoolExpiry, from
+// the mempool and its journal.
+func (bc *Blockchain) ExpireMempool() {
+	if len(bc.Mempool.Expire()) > 0 {
+		bc.rewriteJournal()
+	}
+}
+
+// EvictTransaction drops tx from the mempool, with the sender's later transactions that
+// depended on it, and from the journal.
+func (bc *Blockchain) EvictTransaction(tx *Transaction) {
+	bc.Mempool.RemoveTransactions([]*Transaction{tx})
+	bc.RefreshMempool()
+}
+
+// RefreshMempool revalidates every pending transaction against the current chain and
+// drops those a new block has included or made invalid.
+func (bc *Blockchain) RefreshMempool() {
+	bc.resubmit(nil)
+}
+
+// resubmit empties the mempool and offers it txs followed by the previously pending
+// transactions, keeping those still valid, and returns how many of txs were accepted.
+func (bc *Blockchain) resubmit(txs []*Transaction) int {
+	pending := bc.Mempool.GetTransactions()
+	arrivals := bc.Mempool.arrivals()
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].Nonce < pending[j].Nonce })
 	bc.Mempool.Clear()
+
+	// Journal the outcome once at the end instead of every re-added transaction
+	journal := bc.journal
+	bc.journal = nil
+	defer func() {
+		bc.journal = journal
+		bc.rewriteJournal()
+	}()
+
+	accepted := 0
+	for _, tx := range txs {
+		if tx.From != "" && bc.AddTransactionToMempool(tx) == nil {
+			accepted++
+		}
+	}
+	for _, tx := range pending {
+		bc.AddTransactionToMempool(tx)
+	}
+	bc.Mempool.restoreArrivals(arrivals)
+	return accepted
 }
 
-// GetBalance calculates and returns the balance for a given address
-func (bc *Blockchain) GetBalance(address string) int {
-	balance := 0
-	for _, block := range bc.Blocks {
-		for _, tx := range block.Transactions {
-			if tx.From == address {
-				balance -= tx.Amount
+// NextNonce returns the nonce the next transaction from address must carry
+func (bc *Blockchain) NextNonce(address string) uint64 {
+	return bc.Nonces[address]
+}
+
+// AddBlock validates the block, applies its transactions to the UTXO set and appends it to the chain.
+// The block is rejected with a BlockValidationError if its header or proof of work is invalid,
+// any transaction spends outputs that are missing or already spent, or carries a nonce
+// that skips or reuses a sender's sequence number.
+func (bc *Blockchain) AddBlock(block *Block) error {
+	if err := bc.connectBlock(block); err != nil {
+		return err
+	}
+	if bc.store != nil {
+		if err := bc.store.Append(block); err != nil {
+			bc.disconnectBlock()
+			return fmt.Errorf("failed to persist block: %v", err)
+		}
+	}
+	bc.RefreshMempool()
+	return nil
+}
+
+// Close closes the files the chain and the mempool are persisted in, if any.
+func (bc *Blockchain) Close() error {
+	var err error
+	if bc.store != nil {
+		err = bc.store.Close()
+	}
+	if bc.journal != nil {
+		if journalErr := bc.journal.Close(); err == nil {
+			err = journalErr
+		}
+	}
+	return err
+}
+
+// ReplaceBlocks swaps in a whole new chain and rebuilds the UTXO set and nonces from it.
+// The store is written first, and the chain in memory only replaced once it succeeded.
+func (bc *Blockchain) ReplaceBlocks(blocks []*Block) error {
+	utxoSet, nonces, undos, err := chainState(blocks)
+	if err != nil {
+		return err
+	}
+	if bc.store != nil {
+		if err := bc.store.Replace(blocks); err != nil {
+			// The write may have stopped partway, put back the chain still held in memory
+			if restoreErr := bc.store.Replace(bc.Blocks); restoreErr != nil {
+				log.Printf("Failed to restore the stored blockchain: %v", restoreErr)
 			}
-			if tx.To == address {
-				balance += tx.Amount
+			return fmt.Errorf("failed to persist blockchain: %v", err)
+		}
+	}
+	bc.Blocks = blocks
+	bc.UTXOSet = utxoSet
+	bc.Nonces = nonces
+	bc.undos = undos
+	return nil
+}
+
+// chainState validates blocks as a whole chain and builds its UTXO set, nonces and undo
+// records.
+func chainState(blocks []*Block) (*UTXOSet, map[string]uint64, []*blockUndo, error) {
+	nonces := make(map[string]uint64)
+	for height, block := range blocks {
+		if height > 0 {
+			if err := checkBlock(block, blocks[:height]); err != nil {
+				return nil, nil, nil, fmt.Errorf("block %d: %v", height, err)
 			}
 		}
+		updatedNonces, err := blockNonces(nonces, block)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("block %d: %v", height, err)
+		}
+		for address, nonce := range updatedNonces {
+			nonces[address] = nonce
+		}
 	}
-	return balance
+
+	utxoSet := NewUTXOSet()
+	undos := make([]*blockUndo, len(blocks))
+	for height, block := range blocks {
+		undo, err := utxoSet.ApplyBlock(block, height)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("block %d: %v", height, err)
+		}
+		undos[height] = undo
+	}
+	return utxoSet, nonces, undos, nil
+}
+
+// blockNonces checks the nonces of a block's transactions against the expected ones
+// and returns the next expected nonce of every sender in the block.
+func blockNonces(nonces map[string]uint64, block *Block) (map[string]uint64, error) {
+	updated := make(map[string]uint64)
+	for _, tx := range block.Transactions {
+		if tx.From == "" {
+			continue // Genesis and coinbase transactions have no sender
+		}
+
+		expected, ok := updated[tx.From]
+		if !ok {
+			expected = nonces[tx.From]
+		}
+		if tx.Nonce != expected {
+			return nil, fmt.Errorf("transaction %x has nonce %d, expected %d", tx.ID, tx.Nonce, expected)
+		}
+		updated[tx.From] = expected + 1
+	}
+	return updated, nil
+}
+
+// MineBlock mines a block from transactions in the mempool
+func (bc *Blockchain) MineBlock() {
+	lastBlock := bc.Blocks[len(bc.Blocks)-1]
+	newBlock := NewBlockWithDifficulty(bc.NewBlockTemplate("").Transactions, lastBlock.Hash, bc.NextDifficulty())
+	if err := bc.AddBlock(newBlock); err != nil {
+		fmt.Printf("Error: %s\n", err)
+	}
+}
+
+// GetBalance returns the balance for a given address from the UTXO set
+func (bc *Blockchain) GetBalance(address string) int {
+	return bc.UTXOSet.Balance(address)
 }
 
 // IsValidAddress checks if an address is in a valid format
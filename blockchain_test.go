@@ -1,11 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"testing"
 )
 
 func TestNewBlockchain(t *testing.T) {
-	blockchain := NewBlockchain()
+	blockchain := NewBlockchain("")
 
 	if len(blockchain.Blocks) != 1 {
 		t.Errorf("NewBlockchain() failed, expected blockchain length of 1, got %v", len(blockchain.Blocks))
@@ -13,7 +14,7 @@ func TestNewBlockchain(t *testing.T) {
 }
 
 func TestValidateChain(t *testing.T) {
-	blockchain := NewBlockchain()
+	blockchain := NewBlockchain("")
 
 	// 创建并添加一个包含交易的区块
 	transaction := NewTransaction("from", "to", 50)
@@ -42,3 +43,87 @@ func TestValidateChain(t *testing.T) {
 // 		t.Error("AddTransactionToMempool() failed, the transaction was not added to the mempool")
 // 	}
 // }
+
+func TestAddBlockRejectsReplayedNonce(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+
+	blockchain := newTestChain(t, alice.Address())
+	genesis := blockchain.GetLatestBlock()
+
+	tx, _ := NewSignedTransaction(alice, bob.Address(), 10, 0)
+	if err := blockchain.AddTransactionToMempool(tx); err != nil {
+		t.Fatalf("AddTransactionToMempool() failed with error: %v", err)
+	}
+
+	// A gap in the sender's nonces is rejected
+	gap, _ := NewSignedTransaction(alice, bob.Address(), 10, 2)
+	if err := blockchain.AddTransactionToMempool(gap); err == nil {
+		t.Error("AddTransactionToMempool() failed, a nonce gap should be rejected")
+	}
+
+	if err := blockchain.AddBlock(NewBlock([]*Transaction{tx}, genesis.Hash)); err != nil {
+		t.Fatalf("AddBlock() failed with error: %v", err)
+	}
+	if blockchain.NextNonce(alice.Address()) != 1 {
+		t.Errorf("AddBlock() failed, expected next nonce 1, got %d", blockchain.NextNonce(alice.Address()))
+	}
+
+	// Replaying the same signed transaction reuses nonce 0
+	if err := blockchain.AddBlock(NewBlock([]*Transaction{tx}, blockchain.GetLatestBlock().Hash)); err == nil {
+		t.Error("AddBlock() failed, a replayed transaction should be rejected")
+	}
+	if blockchain.GetBalance(bob.Address()) != 10 {
+		t.Errorf("AddBlock() failed, expected balance 10, got %d", blockchain.GetBalance(bob.Address()))
+	}
+}
+
+func TestBlockchainPersistsAcrossRestarts(t *testing.T) {
+	dataDir := t.TempDir()
+
+	blockchain := NewBlockchain(dataDir)
+	t.Cleanup(func() { blockchain.Close() })
+	newBlock := NewBlock([]*Transaction{}, blockchain.GetLatestBlock().Hash)
+	if err := blockchain.AddBlock(newBlock); err != nil {
+		t.Fatalf("AddBlock() failed with error: %v", err)
+	}
+	blockchain.Close()
+
+	restarted := NewBlockchain(dataDir)
+	t.Cleanup(func() { restarted.Close() })
+	if len(restarted.Blocks) != 2 || !bytes.Equal(restarted.GetLatestBlock().Hash, newBlock.Hash) {
+		t.Errorf("NewBlockchain() failed, expected the mined block to be loaded back, got %d blocks", len(restarted.Blocks))
+	}
+}
+
+func TestReplaceBlocksKeepsChainWhenStoreFails(t *testing.T) {
+	blockchain := NewBlockchain(t.TempDir())
+	genesis := blockchain.GetLatestBlock()
+	blockchain.Close() // Every write to the store now fails
+
+	if err := blockchain.ReplaceBlocks([]*Block{genesis, NewBlock([]*Transaction{}, genesis.Hash)}); err == nil {
+		t.Fatal("ReplaceBlocks() failed, expected the store error to be returned")
+	}
+	if len(blockchain.Blocks) != 1 || blockchain.GetLatestBlock() != genesis {
+		t.Errorf("ReplaceBlocks() failed, the chain in memory changed although it was not stored: %d blocks", len(blockchain.Blocks))
+	}
+}
+
+func TestBlockchainDiscardsChainOfOtherGenesis(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := OpenBlockStore(dataDir)
+	if err != nil {
+		t.Fatalf("OpenBlockStore() failed with error: %v", err)
+	}
+	other := NewBlock([]*Transaction{NewTransaction("", NewWallet().Address(), 100)}, []byte{})
+	if err := store.Append(other); err != nil {
+		t.Fatalf("Append() failed with error: %v", err)
+	}
+	store.Close()
+
+	blockchain := NewBlockchain(dataDir)
+	t.Cleanup(func() { blockchain.Close() })
+	if len(blockchain.Blocks) != 1 || !bytes.Equal(blockchain.Blocks[0].Hash, LoadGenesisBlock().Hash) {
+		t.Error("NewBlockchain() failed, expected the chain of another genesis block to be replaced by genesis.block")
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	blockFileName   = "blocks.dat" // Append-only file of block records
+	indexFileName   = "blocks.idx" // Fixed-size index entries, one per height
+	recordHeaderLen = 8            // 4-byte length followed by 4-byte CRC32 of the payload
+	indexEntryLen   = 8 + 32       // 8-byte record offset followed by the 32-byte block hash
+	maxRecordLen    = 32 << 20     // Upper bound on a single block record
+)
+
+var ErrBlockNotFound = errors.New("block not found")
+
+// BlockStore persists a node's chain in a per-node data directory.
+//
+// Blocks are appended to blocks.dat as length-prefixed, checksummed JSON records.
+// blocks.idx maps each height to its record offset and block hash. Every write is
+// synced before the next one starts, so after a crash at most the last record is
+// incomplete; OpenBlockStore drops such a tail and repairs the index from the data file.
+type BlockStore struct {
+	mutex     sync.Mutex
+	dataFile  *os.File
+	indexFile *os.File
+	offsets   []int64  // Record offset by height
+	hashes    []string // Hex-encoded block hash by height
+	size      int64    // Offset just past the last valid record
+}
+
+// OpenBlockStore opens or creates the block store in dir and recovers it from an unclean shutdown.
+func OpenBlockStore(dir string) (*BlockStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	dataFile, err := os.OpenFile(filepath.Join(dir, blockFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	indexFile, err := os.OpenFile(filepath.Join(dir, indexFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		dataFile.Close()
+		return nil, err
+	}
+
+	s := &BlockStore{
+		dataFile:  dataFile,
+		indexFile: indexFile,
+	}
+	if err := s.recover(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover scans the data file, truncates an incomplete tail and rebuilds the index if it disagrees.
+func (s *BlockStore) recover() error {
+	var offsets []int64
+	var hashes [][]byte
+
+	if _, err := s.dataFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(s.dataFile)
+	var offset int64
+	for {
+		block, n, err := readRecord(reader)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Block store: dropping damaged tail at offset %d: %v", offset, err)
+			}
+			break
+		}
+		offsets = append(offsets, offset)
+		hashes = append(hashes, block.Hash)
+		offset += n
+	}
+
+	if err := s.dataFile.Truncate(offset); err != nil {
+		return err
+	}
+	s.size = offset
+
+	if !s.indexMatches(offsets, hashes) {
+		log.Printf("Block store: rebuilding index for %d blocks", len(offsets))
+		if err := s.writeIndex(offsets, hashes); err != nil {
+			return err
+		}
+	}
+
+	s.offsets = offsets
+	for _, hash := range hashes {
+		s.hashes = append(s.hashes, hex.EncodeToString(hash))
+	}
+	return s.sync()
+}
+
+// indexMatches reports whether the index file describes exactly the scanned records.
+func (s *BlockStore) indexMatches(offsets []int64, hashes [][]byte) bool {
+	data, err := io.ReadAll(io.NewSectionReader(s.indexFile, 0, 1<<62))
+	if err != nil || len(data) != len(offsets)*indexEntryLen {
+		return false
+	}
+	for height := range offsets {
+		entry := data[height*indexEntryLen : (height+1)*indexEntryLen]
+		if !bytes.Equal(entry, indexEntry(offsets[height], hashes[height])) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeIndex replaces the index file with entries for the given records.
+func (s *BlockStore) writeIndex(offsets []int64, hashes [][]byte) error {
+	if err := s.indexFile.Truncate(0); err != nil {
+		return err
+	}
+	for height := range offsets {
+		if _, err := s.indexFile.WriteAt(indexEntry(offsets[height], hashes[height]), int64(height*indexEntryLen)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Blocks loads every stored block in height order.
+func (s *BlockStore) Blocks() ([]*Block, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	blocks := make([]*Block, 0, len(s.offsets))
+	reader := bufio.NewReader(io.NewSectionReader(s.dataFile, 0, s.size))
+	for range s.offsets {
+		block, _, err := readRecord(reader)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// Height returns the number of stored blocks.
+func (s *BlockStore) Height() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.offsets)
+}
+
+// Append writes a block at the next height. The record is synced to disk before the index entry.
+func (s *BlockStore) Append(block *Block) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	payload, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, recordHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderLen:], payload)
+
+	if _, err := s.dataFile.WriteAt(record, s.size); err != nil {
+		return err
+	}
+	if err := s.dataFile.Sync(); err != nil {
+		return err
+	}
+
+	height := len(s.offsets)
+	if _, err := s.indexFile.WriteAt(indexEntry(s.size, block.Hash), int64(height*indexEntryLen)); err != nil {
+		return err
+	}
+	if err := s.indexFile.Sync(); err != nil {
+		return err
+	}
+
+	s.offsets = append(s.offsets, s.size)
+	s.hashes = append(s.hashes, hex.EncodeToString(block.Hash))
+	s.size += int64(len(record))
+	return nil
+}
+
+// Replace makes the store hold exactly blocks, keeping the prefix it already shares with them.
+func (s *BlockStore) Replace(blocks []*Block) error {
+	s.mutex.Lock()
+	common := 0
+	for common < len(s.hashes) && common < len(blocks) && s.hashes[common] == hex.EncodeToString(blocks[common].Hash) {
+		common++
+	}
+	s.mutex.Unlock()
+
+	if err := s.Truncate(common); err != nil {
+		return err
+	}
+	for _, block := range blocks[common:] {
+		if err := s.Append(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Truncate removes every block at or above height.
+func (s *BlockStore) Truncate(height int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if height < 0 || height >= len(s.offsets) {
+		return nil
+	}
+
+	// Shrink the index first so it never points past the end of the data file
+	if err := s.indexFile.Truncate(int64(height * indexEntryLen)); err != nil {
+		return err
+	}
+	if err := s.dataFile.Truncate(s.offsets[height]); err != nil {
+		return err
+	}
+	s.size = s.offsets[height]
+	s.offsets = s.offsets[:height]
+	s.hashes = s.hashes[:height]
+	return s.sync()
+}
+
+// Close closes the underlying files.
+func (s *BlockStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dataErr := s.dataFile.Close()
+	indexErr := s.indexFile.Close()
+	if dataErr != nil {
+		return dataErr
+	}
+	return indexErr
+}
+
+func (s *BlockStore) sync() error {
+	if err := s.dataFile.Sync(); err != nil {
+		return err
+	}
+	return s.indexFile.Sync()
+}
+
+// readRecord reads one block record and returns the block and the record length.
+func readRecord(reader *bufio.Reader) (*Block, int64, error) {
+	header := make([]byte, recordHeaderLen)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, errors.New("incomplete record header")
+		}
+		return nil, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length == 0 || length > maxRecordLen {
+		return nil, 0, fmt.Errorf("invalid record length %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, errors.New("incomplete record")
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+
+	var block Block
+	if err := json.Unmarshal(payload, &block); err != nil {
+		return nil, 0, err
+	}
+	return &block, int64(recordHeaderLen + len(payload)), nil
+}
+
+// indexEntry encodes the index entry of a record.
+func indexEntry(offset int64, hash []byte) []byte {
+	entry := make([]byte, indexEntryLen)
+	binary.BigEndian.PutUint64(entry[:8], uint64(offset))
+	copy(entry[8:], hash)
+	return entry
+}
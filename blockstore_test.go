@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestBlockStore opens the store in dir, closed when the test ends.
+func openTestBlockStore(t *testing.T, dir string) *BlockStore {
+	store, err := OpenBlockStore(dir)
+	if err != nil {
+		t.Fatalf("OpenBlockStore() failed with error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestBlockStoreAppendAndLookup(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestBlockStore(t, dir)
+
+	genesis := NewBlock([]*Transaction{}, []byte{})
+	second := NewBlock([]*Transaction{}, genesis.Hash)
+	for _, block := range []*Block{genesis, second} {
+		if err := store.Append(block); err != nil {
+			t.Fatalf("Append() failed with error: %v", err)
+		}
+	}
+	store.Close()
+
+	store = openTestBlockStore(t, dir)
+	if store.Height() != 2 {
+		t.Errorf("OpenBlockStore() failed, expected 2 blocks, got %d", store.Height())
+	}
+	blocks, err := store.Blocks()
+	if err != nil || len(blocks) != 2 || !bytes.Equal(blocks[0].Hash, genesis.Hash) || !bytes.Equal(blocks[1].Hash, second.Hash) {
+		t.Error("Blocks() failed, the stored blocks were not read back in order")
+	}
+}
+
+func TestBlockStoreRecoversTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestBlockStore(t, dir)
+
+	genesis := NewBlock([]*Transaction{}, []byte{})
+	second := NewBlock([]*Transaction{}, genesis.Hash)
+	store.Append(genesis)
+	store.Append(second)
+	store.Close()
+
+	// Simulate a crash in the middle of writing the second record
+	dataFile := filepath.Join(dir, blockFileName)
+	info, _ := os.Stat(dataFile)
+	if err := os.Truncate(dataFile, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openTestBlockStore(t, dir)
+	if store.Height() != 1 {
+		t.Errorf("OpenBlockStore() failed, expected the damaged block to be dropped, got %d blocks", store.Height())
+	}
+	if blocks, err := store.Blocks(); err != nil || len(blocks) != 1 {
+		t.Error("OpenBlockStore() failed, the index should no longer reference the damaged block")
+	}
+
+	// The store stays appendable after recovery
+	if err := store.Append(second); err != nil {
+		t.Fatalf("Append() failed with error: %v", err)
+	}
+	blocks, err := store.Blocks()
+	if err != nil || len(blocks) != 2 {
+		t.Errorf("Blocks() failed, expected 2 blocks after re-appending, got %d (%v)", len(blocks), err)
+	}
+}
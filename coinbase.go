@@ -0,0 +1,43 @@
+package main
+
+var (
+	InitialSubsidy   = 50  // Coins created by each block before the first halving
+	HalvingInterval  = 100 // Number of blocks between subsidy halvings
+	CoinbaseMaturity = 10  // Blocks that must follow a coinbase before its output can be spent
+)
+
+// BlockSubsidy returns the number of new coins a block at height may create.
+// The subsidy halves every HalvingInterval blocks until it reaches zero.
+func BlockSubsidy(height int) int {
+	halvings := height / HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return InitialSubsidy >> uint(halvings)
+}
+
+// NewCoinbaseTransaction creates the transaction paying a block's reward to the miner.
+// The height is stored in the nonce so coinbases paying the same amount get distinct IDs.
+func NewCoinbaseTransaction(to string, height int, reward int) *Transaction {
+	tx := NewTransaction("", to, reward)
+	tx.Nonce = uint64(height)
+	tx.ID = tx.Hash()
+	return tx
+}
+
+// IsCoinbase reports whether the transaction creates new coins instead of spending existing ones.
+// Outside the genesis block only the first transaction of a block may be a coinbase.
+func (tx *Transaction) IsCoinbase() bool {
+	return tx.From == ""
+}
+
+// NewCoinbase returns the coinbase for a block on top of this chain containing txs,
+// paying the subsidy plus the fees of txs to miner.
+func (bc *Blockchain) NewCoinbase(miner string, txs []*Transaction) *Transaction {
+	height := len(bc.Blocks)
+	reward := BlockSubsidy(height)
+	for _, tx := range txs {
+		reward += tx.Fee
+	}
+	return NewCoinbaseTransaction(miner, height, reward)
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestBlockSubsidyHalves(t *testing.T) {
+	if BlockSubsidy(1) != InitialSubsidy {
+		t.Errorf("BlockSubsidy() failed, expected %d, got %d", InitialSubsidy, BlockSubsidy(1))
+	}
+	if BlockSubsidy(HalvingInterval) != InitialSubsidy/2 {
+		t.Errorf("BlockSubsidy() failed, expected %d after one halving, got %d", InitialSubsidy/2, BlockSubsidy(HalvingInterval))
+	}
+	if BlockSubsidy(64*HalvingInterval) != 0 {
+		t.Error("BlockSubsidy() failed, the subsidy should run out")
+	}
+}
+
+func TestCoinbaseRewardAndMaturity(t *testing.T) {
+	defer func(maturity int) { CoinbaseMaturity = maturity }(CoinbaseMaturity)
+	CoinbaseMaturity = 2
+
+	miner := NewWallet()
+	bob := NewWallet()
+	blockchain := newTestChain(t)
+
+	// A coinbase paying more than the subsidy is rejected
+	greedy := NewCoinbaseTransaction(miner.Address(), 1, BlockSubsidy(1)+1)
+	if err := blockchain.AddBlock(NewBlock([]*Transaction{greedy}, blockchain.GetLatestBlock().Hash)); err == nil {
+		t.Error("AddBlock() failed, a coinbase above the subsidy should be rejected")
+	}
+
+	coinbase := blockchain.NewCoinbase(miner.Address(), nil)
+	if err := blockchain.AddBlock(NewBlock([]*Transaction{coinbase}, blockchain.GetLatestBlock().Hash)); err != nil {
+		t.Fatalf("AddBlock() failed with error: %v", err)
+	}
+	if blockchain.GetBalance(miner.Address()) != BlockSubsidy(1) {
+		t.Errorf("AddBlock() failed, expected miner balance %d, got %d", BlockSubsidy(1), blockchain.GetBalance(miner.Address()))
+	}
+
+	// The reward cannot be spent until CoinbaseMaturity blocks have passed
+	tx, _ := NewSignedTransaction(miner, bob.Address(), 10, 0)
+	if err := blockchain.AddTransactionToMempool(tx); err == nil {
+		t.Error("AddTransactionToMempool() failed, an immature coinbase should not be spendable")
+	}
+	if err := blockchain.AddBlock(NewBlock([]*Transaction{}, blockchain.GetLatestBlock().Hash)); err != nil {
+		t.Fatalf("AddBlock() failed with error: %v", err)
+	}
+	if err := blockchain.AddTransactionToMempool(tx); err != nil {
+		t.Errorf("AddTransactionToMempool() failed, a mature coinbase should be spendable: %v", err)
+	}
+}
+
+func TestFeesPaidToMiner(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+	miner := NewWallet()
+
+	blockchain := newTestChain(t, alice.Address())
+	genesis := blockchain.GetLatestBlock()
+
+	tx, _ := NewSignedTransactionWithFee(alice, bob.Address(), 30, 5, 0)
+	txs := []*Transaction{blockchain.NewCoinbase(miner.Address(), []*Transaction{tx}), tx}
+	if err := blockchain.AddBlock(NewBlock(txs, genesis.Hash)); err != nil {
+		t.Fatalf("AddBlock() failed with error: %v", err)
+	}
+
+	if blockchain.GetBalance(alice.Address()) != 65 || blockchain.GetBalance(bob.Address()) != 30 {
+		t.Errorf("AddBlock() failed, expected balances 65/30, got %d/%d",
+			blockchain.GetBalance(alice.Address()), blockchain.GetBalance(bob.Address()))
+	}
+	if blockchain.GetBalance(miner.Address()) != BlockSubsidy(1)+5 {
+		t.Errorf("AddBlock() failed, expected miner balance %d, got %d", BlockSubsidy(1)+5, blockchain.GetBalance(miner.Address()))
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math/big"
+	"time"
+)
+
+const (
+	minDifficulty = 1  // Lowest difficulty a retarget may reach
+	maxDifficulty = 64 // Highest difficulty a retarget may reach
+)
+
+var (
+	TargetBlockInterval = 10 * time.Second // Desired average time between blocks
+	RetargetInterval    = 10               // Number of blocks between difficulty adjustments
+)
+
+// requiredDifficulty returns the difficulty the retargeting rule demands for the block
+// that follows chain.
+//
+// Difficulty is counted in leading zero bits, so each step doubles or halves the work.
+// Every RetargetInterval blocks the time the last RetargetInterval blocks took is
+// compared with the target: blocks twice as fast raise the difficulty by one bit,
+// blocks twice as slow lower it by one. In between the previous difficulty carries over.
+// The genesis block is left out of the first window, since its timestamp tells when the
+// network was set up rather than how fast blocks are mined.
+func requiredDifficulty(chain []*Block) int {
+	if len(chain) == 0 {
+		return targetBits
+	}
+
+	last := chain[len(chain)-1]
+	difficulty := last.Difficulty
+	if difficulty == 0 {
+		difficulty = targetBits // Blocks created before difficulty was recorded used the initial one
+	}
+
+	if len(chain)%RetargetInterval != 0 {
+		return difficulty
+	}
+	start := len(chain) - 1 - RetargetInterval
+	if start < 1 {
+		start = 1
+	}
+	intervals := len(chain) - 1 - start
+	if intervals < 1 {
+		return difficulty
+	}
+
+	first := chain[start]
+	actual := time.Duration(last.Timestamp-first.Timestamp) * time.Second
+	expected := time.Duration(intervals) * TargetBlockInterval
+
+	switch {
+	case actual < expected/2 && difficulty < maxDifficulty:
+		difficulty++
+	case actual > expected*2 && difficulty > minDifficulty:
+		difficulty--
+	}
+	return difficulty
+}
+
+// NextDifficulty returns the difficulty the next block on this chain must be mined with.
+func (bc *Blockchain) NextDifficulty() int {
+	return requiredDifficulty(bc.Blocks)
+}
+
+// blockWork returns the expected number of hashes needed to mine a block, 2^difficulty.
+func blockWork(block *Block) *big.Int {
+	difficulty := block.Difficulty
+	if difficulty == 0 {
+		difficulty = targetBits
+	}
+	return new(big.Int).Lsh(big.NewInt(1), uint(difficulty))
+}
+
+// chainWork returns the total proof of work of a sequence of blocks.
+func chainWork(blocks []*Block) *big.Int {
+	work := new(big.Int)
+	for _, block := range blocks {
+		work.Add(work, blockWork(block))
+	}
+	return work
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+// chainWithSpacing builds a chain of n blocks at the given difficulty, spaced by seconds
+func chainWithSpacing(n int, seconds int64, difficulty int) []*Block {
+	var chain []*Block
+	for i := 0; i < n; i++ {
+		chain = append(chain, &Block{Timestamp: int64(i) * seconds, Difficulty: difficulty})
+	}
+	return chain
+}
+
+func TestRequiredDifficultyRetargets(t *testing.T) {
+	interval := int64(TargetBlockInterval.Seconds())
+
+	fast := chainWithSpacing(RetargetInterval+1, interval/4, 5)
+	if got := requiredDifficulty(fast); got != 5 {
+		t.Errorf("requiredDifficulty() failed, expected the difficulty to carry over between retargets, got %d", got)
+	}
+	if got := requiredDifficulty(fast[:RetargetInterval]); got != 6 {
+		t.Errorf("requiredDifficulty() failed, expected the first retarget after RetargetInterval blocks, got %d", got)
+	}
+
+	// The genesis block, here mined long before the others, is not part of the first window
+	fast[0].Timestamp -= 100 * interval
+	if got := requiredDifficulty(fast[:RetargetInterval]); got != 6 {
+		t.Errorf("requiredDifficulty() failed, expected the genesis timestamp to be ignored, got %d", got)
+	}
+
+	fast = chainWithSpacing(2*RetargetInterval, interval/4, 5)
+	if got := requiredDifficulty(fast); got != 6 {
+		t.Errorf("requiredDifficulty() failed, expected fast blocks to raise the difficulty to 6, got %d", got)
+	}
+
+	slow := chainWithSpacing(2*RetargetInterval, interval*4, 5)
+	if got := requiredDifficulty(slow); got != 4 {
+		t.Errorf("requiredDifficulty() failed, expected slow blocks to lower the difficulty to 4, got %d", got)
+	}
+
+	onTime := chainWithSpacing(2*RetargetInterval, interval, 5)
+	if got := requiredDifficulty(onTime); got != 5 {
+		t.Errorf("requiredDifficulty() failed, expected on-time blocks to keep the difficulty, got %d", got)
+	}
+}
+
+func TestValidateChainRejectsWrongDifficulty(t *testing.T) {
+	blockchain := NewBlockchain("")
+
+	newBlock := NewBlockWithDifficulty([]*Transaction{}, blockchain.GetLatestBlock().Hash, targetBits-1)
+	blockchain.Blocks = append(blockchain.Blocks, newBlock)
+	if blockchain.ValidateChain() {
+		t.Error("ValidateChain() failed, a block mined below the required difficulty should be rejected")
+	}
+}
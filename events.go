@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types published by a node.
+const (
+	EventBlock     = "block"     // A block was connected to the chain
+	EventReorg     = "reorg"     // Blocks were disconnected for a branch with more work
+	EventTx        = "tx"        // A transaction was accepted into the mempool
+	EventConfirmed = "confirmed" // A transaction was included in a connected block
+)
+
+const (
+	eventBufferSize    = 256              // Events queued per subscriber before new ones are dropped
+	eventKeepAlive     = 15 * time.Second // Interval between comments keeping an idle event stream open
+	eventWatchInterval = 5 * time.Second  // Interval between checks for nodes whose events are not followed
+)
+
+// Event is something that happened to a node's chain or mempool.
+type Event struct {
+	Seq       uint64 // Increasing number of the event on the publishing node
+	Type      string
+	Time      time.Time
+	Height    int      `json:",omitempty"` // Of the block, or the last block kept by a reorg
+	BlockHash []byte   `json:",omitempty"` // The block, or the new tip after a reorg
+	TxID      []byte   `json:",omitempty"`
+	Addresses []string `json:",omitempty"` // Senders and receivers of the transactions concerned
+	OldTip    []byte   `json:",omitempty"` // Tip abandoned by a reorg
+	Depth     int      `json:",omitempty"` // Blocks disconnected by a reorg
+}
+
+// EventFilter selects events by type and address. Empty fields select everything.
+// Events without addresses, such as reorgs, pass any address filter.
+type EventFilter struct {
+	Topics  []string
+	Address string
+}
+
+func (f EventFilter) matches(event Event) bool {
+	if len(f.Topics) > 0 {
+		found := false
+		for _, topic := range f.Topics {
+			if topic == event.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Address == "" || len(event.Addresses) == 0 {
+		return true
+	}
+	for _, address := range event.Addresses {
+		if address == f.Address {
+			return true
+		}
+	}
+	return false
+}
+
+// query encodes the filter as the query string of an event stream URL.
+func (f EventFilter) query() string {
+	values := url.Values{}
+	if len(f.Topics) > 0 {
+		values.Set("topics", strings.Join(f.Topics, ","))
+	}
+	if f.Address != "" {
+		values.Set("address", f.Address)
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+// Subscription receives the events matching its filter on C. A subscriber too slow to
+// keep up loses the events published while its buffer is full.
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	filter EventFilter
+}
+
+// EventBus hands the events published by a node to its subscribers.
+type EventBus struct {
+	mutex       sync.Mutex
+	seq         uint64
+	subscribers map[*Subscription]bool
+}
+
+// NewEventBus creates an event bus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[*Subscription]bool)}
+}
+
+// Subscribe returns a subscription to the events matching filter.
+func (b *EventBus) Subscribe(filter EventFilter) *Subscription {
+	c := make(chan Event, eventBufferSize)
+	s := &Subscription{C: c, c: c, filter: filter}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.subscribers[s] = true
+	return s
+}
+
+// Unsubscribe ends a subscription and closes its channel.
+func (b *EventBus) Unsubscribe(s *Subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.subscribers[s] {
+		delete(b.subscribers, s)
+		close(s.c)
+	}
+}
+
+// Publish numbers and timestamps event and hands it to the matching subscribers. It
+// never blocks, so it may be called with the blockchain locked.
+func (b *EventBus) Publish(event Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.seq++
+	event.Seq = b.seq
+	event.Time = time.Now()
+	for s := range b.subscribers {
+		if !s.filter.matches(event) {
+			continue
+		}
+		select {
+		case s.c <- event:
+		default:
+		}
+	}
+}
+
+// txAddresses returns the addresses a transaction takes from or pays to.
+func txAddresses(tx *Transaction) []string {
+	var addresses []string
+	seen := make(map[string]bool)
+	add := func(address string) {
+		if address != "" && !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	add(tx.From)
+	add(tx.To)
+	for _, output := range tx.Outputs {
+		add(output.Address)
+	}
+	return addresses
+}
+
+// publishChainEvents publishes what changed in the chain since the last call: a reorg
+// if blocks were disconnected, then every connected block and the transactions it
+// confirms. Must be called with the blockchain locked.
+func (node *Node) publishChainEvents() {
+	old := node.eventChain
+	blocks := node.Blockchain.Blocks
+	fork := 0
+	for fork < len(old) && fork < len(blocks) && bytes.Equal(old[fork].Hash, blocks[fork].Hash) {
+		fork++
+	}
+	if fork < len(old) && len(blocks) > 0 {
+		node.Events.Publish(Event{
+			Type:      EventReorg,
+			Height:    fork - 1,
+			BlockHash: blocks[len(blocks)-1].Hash,
+			OldTip:    old[len(old)-1].Hash,
+			Depth:     len(old) - fork,
+		})
+	}
+	for height := fork; height < len(blocks); height++ {
+		block := blocks[height]
+		var addresses []string
+		for _, tx := range block.Transactions {
+			addresses = append(addresses, txAddresses(tx)...)
+		}
+		node.Events.Publish(Event{Type: EventBlock, Height: height, BlockHash: block.Hash, Addresses: addresses})
+		for _, tx := range block.Transactions {
+			node.Events.Publish(Event{Type: EventConfirmed, Height: height, BlockHash: block.Hash, TxID: tx.ID, Addresses: txAddresses(tx)})
+		}
+	}
+	node.eventChain = append(node.eventChain[:0:0], blocks...)
+}
+
+// EventsHandler streams the node's events as Server-Sent Events. The query parameters
+// topics, a comma separated list of event types, and address filter the stream. Banned
+// peers are refused, as on the JSON-RPC endpoint.
+func (node *Node) EventsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if node.Guard.IsBanned(r.RemoteAddr) {
+			http.Error(w, ErrPeerBanned.Error(), http.StatusForbidden)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		filter := EventFilter{Topics: parseSeeds(r.URL.Query().Get("topics")), Address: r.URL.Query().Get("address")}
+		subscription := node.Events.Subscribe(filter)
+		defer node.Events.Unsubscribe(subscription)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(eventKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case event := <-subscription.C:
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Printf("Error encoding event: %v", err)
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// followEvents reads the event stream at url, calling handle for each event, until the
+// stream ends.
+func followEvents(url string, handle func(Event)) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("event stream answered %s", resp.Status)
+	}
+
+	var data bytes.Buffer
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxJSONRPCRequestSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		case line == "" && data.Len() > 0:
+			var event Event
+			if err := json.Unmarshal(data.Bytes(), &event); err != nil {
+				log.Printf("Ignoring malformed event from %s: %v", url, err)
+			} else {
+				handle(event)
+			}
+			data.Reset()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("event stream closed")
+}
+
+// eventStreamURL returns the URL of the event stream of peer, the address it was dialed
+// at, given the RPC address its handshake advertised. Only the port is taken from the
+// advertised address, so a peer cannot point the stream at another host; a loopback RPC
+// address advertised by a peer on another host is unreachable and refused.
+func eventStreamURL(peer, rpcAddress string, filter EventFilter) (string, bool) {
+	host, _, err := net.SplitHostPort(peer)
+	if err != nil {
+		return "", false
+	}
+	_, port, err := net.SplitHostPort(rpcAddress)
+	if err != nil || port == "" {
+		return "", false
+	}
+	if isLoopback(rpcAddress) && !isLoopback(peer) {
+		return "", false
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/events" + filter.query(), true
+}
+
+// watchEvents follows the event streams of the outbound nodes that serve one, as told
+// by their handshake, calling handle for every event matching filter. Streams that end
+// are reopened at the next check. It never returns.
+func watchEvents(conns *ConnManager, filter EventFilter, handle func(Event)) {
+	var mutex sync.Mutex
+	watching := make(map[string]bool)
+	for {
+		for _, peer := range conns.Peers.Outbound() {
+			handshake := conns.Handshake(peer)
+			if handshake == nil || handshake.RPCAddress == "" {
+				continue
+			}
+			streamURL, ok := eventStreamURL(peer, handshake.RPCAddress, filter)
+			if !ok {
+				continue
+			}
+			mutex.Lock()
+			if watching[streamURL] {
+				mutex.Unlock()
+				continue
+			}
+			watching[streamURL] = true
+			mutex.Unlock()
+
+			go func() {
+				err := followEvents(streamURL, handle)
+				log.Printf("Stopped following events of %s: %v", streamURL, err)
+				mutex.Lock()
+				delete(watching, streamURL)
+				mutex.Unlock()
+			}()
+		}
+		time.Sleep(eventWatchInterval)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event of a subscription, failing the test after 5 seconds.
+func nextEvent(t *testing.T, s *Subscription) Event {
+	select {
+	case event := <-s.C:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+func TestEventBusFiltersByTopicAndAddress(t *testing.T) {
+	bus := NewEventBus()
+	blocks := bus.Subscribe(EventFilter{Topics: []string{EventBlock, EventReorg}})
+	alice := bus.Subscribe(EventFilter{Address: "alice"})
+
+	bus.Publish(Event{Type: EventTx, Addresses: []string{"bob", "carol"}})
+	bus.Publish(Event{Type: EventBlock, Addresses: []string{"alice"}})
+	bus.Publish(Event{Type: EventReorg})
+
+	if event := nextEvent(t, blocks); event.Type != EventBlock || event.Seq != 2 {
+		t.Errorf("Subscribe() failed, expected block event 2, got %+v", event)
+	}
+	if event := nextEvent(t, blocks); event.Type != EventReorg {
+		t.Errorf("Subscribe() failed, expected the reorg, got %+v", event)
+	}
+	if event := nextEvent(t, alice); event.Type != EventBlock {
+		t.Errorf("Subscribe() failed, expected alice's block, got %+v", event)
+	}
+	if event := nextEvent(t, alice); event.Type != EventReorg {
+		t.Errorf("Subscribe() failed, expected reorgs to pass the address filter, got %+v", event)
+	}
+
+	bus.Unsubscribe(alice)
+	if _, open := <-alice.C; open {
+		t.Error("Unsubscribe() failed, channel still open")
+	}
+}
+
+func TestNodePublishesChainEvents(t *testing.T) {
+	defer func(interval int) { RetargetInterval = interval }(RetargetInterval)
+	RetargetInterval = 1000
+
+	genesis := NewBlock([]*Transaction{}, []byte{})
+	shared := extendChain([]*Block{genesis}, 1)
+	node := NewNode("127.0.0.1:4800", newChainFrom(t, shared))
+	events := node.Events.Subscribe(EventFilter{Topics: []string{EventBlock, EventReorg}})
+
+	// Mined a second later than the branch below, so the blocks at height 2 differ
+	ours := &Block{Timestamp: shared[1].Timestamp + 1, Transactions: []*Transaction{}, PrevBlockHash: shared[1].Hash, Difficulty: targetBits}
+	ours.MineBlock()
+	if added, err := node.AddBlockToBlockchain(ours); !added || err != nil {
+		t.Fatalf("AddBlockToBlockchain() failed, got %v with error %v", added, err)
+	}
+	if event := nextEvent(t, events); event.Type != EventBlock || event.Height != 2 || !bytes.Equal(event.BlockHash, ours.Hash) {
+		t.Errorf("AddBlockToBlockchain() failed, expected a block event at height 2, got %+v", event)
+	}
+
+	// A longer branch replaces our block
+	branch := extendChain(shared, 2)
+	node.BlockchainMutex.Lock()
+	if _, err := node.Blockchain.Reorganize(branch); err != nil {
+		t.Fatalf("Reorganize() failed with error: %v", err)
+	}
+	node.publishChainEvents()
+	node.BlockchainMutex.Unlock()
+
+	reorg := nextEvent(t, events)
+	if reorg.Type != EventReorg || reorg.Depth != 1 || reorg.Height != 1 || !bytes.Equal(reorg.OldTip, ours.Hash) {
+		t.Errorf("publishChainEvents() failed, expected a reorg of one block, got %+v", reorg)
+	}
+	for height := 2; height <= 3; height++ {
+		if event := nextEvent(t, events); event.Type != EventBlock || event.Height != height {
+			t.Errorf("publishChainEvents() failed, expected a block event at height %d, got %+v", height, event)
+		}
+	}
+}
+
+func TestEventStreamDeliversTransactions(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+	node := NewNode("127.0.0.1:4801", newTestChain(t, alice.Address()))
+	server := httptest.NewServer(node.EventsHandler())
+	defer server.Close()
+	defer server.CloseClientConnections() // Ends the stream, which would keep Close waiting
+
+	received := make(chan Event, 10)
+	go followEvents(server.URL+EventFilter{Topics: []string{EventTx}, Address: bob.Address()}.query(), func(event Event) {
+		received <- event
+	})
+	if !waitFor(func() bool {
+		node.Events.mutex.Lock()
+		defer node.Events.mutex.Unlock()
+		return len(node.Events.subscribers) == 1
+	}) {
+		t.Fatal("followEvents() failed, no subscription")
+	}
+
+	tx, _ := NewSignedTransaction(alice, bob.Address(), 10, 0)
+	if err := node.acceptTransaction(tx); err != nil {
+		t.Fatalf("acceptTransaction() failed with error: %v", err)
+	}
+	select {
+	case event := <-received:
+		if event.Type != EventTx || !bytes.Equal(event.TxID, tx.ID) {
+			t.Errorf("followEvents() failed, expected the transaction, got %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("followEvents() failed, no event received")
+	}
+}
+
+func TestEventStreamRefusesBannedPeers(t *testing.T) {
+	node := NewNode("127.0.0.1:4802", newTestChain(t))
+	node.Guard.Misbehaving("192.0.2.1:4000", BanThreshold, "test")
+
+	request := httptest.NewRequest(http.MethodGet, "/events", nil)
+	request.RemoteAddr = "192.0.2.1:4000"
+	recorder := httptest.NewRecorder()
+	node.EventsHandler().ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("EventsHandler() failed, expected 403 for a banned peer, got %d", recorder.Code)
+	}
+}
+
+func TestEventStreamURLUsesDialedHost(t *testing.T) {
+	tests := []struct {
+		peer, rpcAddress, url string
+	}{
+		{"127.0.0.1:3000", "127.0.0.1:8545", "http://127.0.0.1:8545/events"},
+		{"192.168.1.20:3000", "0.0.0.0:8545", "http://192.168.1.20:8545/events"},
+		{"192.168.1.20:3000", "10.0.0.1:8545", "http://192.168.1.20:8545/events"},
+		{"192.168.1.20:3000", "127.0.0.1:8545", ""},
+		{"192.168.1.20:3000", "8545", ""},
+	}
+	for _, test := range tests {
+		url, ok := eventStreamURL(test.peer, test.rpcAddress, EventFilter{})
+		if ok != (test.url != "") || url != test.url {
+			t.Errorf("eventStreamURL(%q, %q) = %q, %v, expected %q", test.peer, test.rpcAddress, url, ok, test.url)
+		}
+	}
+}
@@ -1,3 +1,5 @@
 module blockchain-app
 
 go 1.21.4
+
+require golang.org/x/crypto v0.21.0
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+const (
+	maxInvItems       = 500  // Items accepted in one inv or getdata message
+	maxKnownInventory = 5000 // Items remembered per peer, and items remembered as processed
+	maxHeldTxs        = 500  // Relayed transactions held until their sender's previous nonce arrives
+)
+
+// InvType is the kind of an inventory item.
+type InvType int
+
+const (
+	InvBlock InvType = iota
+	InvTx
+)
+
+// InvItem names a block or a transaction by its hash.
+type InvItem struct {
+	Type InvType
+	Hash []byte
+}
+
+func (item InvItem) key() string {
+	return fmt.Sprintf("%d:%x", item.Type, item.Hash)
+}
+
+// InvMessage announces items the sender has. From is the sender's listening address,
+// where the items can be requested with GetData. Only announcements from peers in the
+// table are followed.
+type InvMessage struct {
+	From  string
+	Items []InvItem
+}
+
+// GetDataReply carries the requested items the sender still had.
+type GetDataReply struct {
+	Blocks       []*Block
+	Transactions []*Transaction
+}
+
+// inventorySet remembers up to limit items, forgetting the oldest first.
+type inventorySet struct {
+	keys  map[string]bool
+	order []string
+	limit int
+}
+
+func newInventorySet(limit int) *inventorySet {
+	return &inventorySet{keys: make(map[string]bool), limit: limit}
+}
+
+// add adds key and reports whether it was new.
+func (s *inventorySet) add(key string) bool {
+	if s.keys[key] {
+		return false
+	}
+	if len(s.order) >= s.limit {
+		delete(s.keys, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.keys[key] = true
+	s.order = append(s.order, key)
+	return true
+}
+
+// Gossip keeps the state of inventory relay: the items each peer is known to have, so
+// nothing is announced back to where it came from, the items already processed and
+// the items being fetched, so each is requested once. Relayed transactions that arrive
+// before their sender's previous nonce are held until it does.
+type Gossip struct {
+	mutex     sync.Mutex
+	known     map[string]*inventorySet // Per address of a peer in the table
+	processed *inventorySet
+	inFlight  map[string]bool
+	held      map[string]*Transaction // By sender and nonce
+}
+
+// NewGossip creates an empty gossip state.
+func NewGossip() *Gossip {
+	return &Gossip{
+		known:     make(map[string]*inventorySet),
+		processed: newInventorySet(maxKnownInventory),
+		inFlight:  make(map[string]bool),
+		held:      make(map[string]*Transaction),
+	}
+}
+
+func heldKey(from string, nonce uint64) string {
+	return fmt.Sprintf("%s:%d", from, nonce)
+}
+
+// hold keeps tx until its sender's previous nonce is accepted. When maxHeldTxs are
+// already held, an arbitrary one is dropped to make room.
+func (g *Gossip) hold(tx *Transaction) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if len(g.held) >= maxHeldTxs {
+		for key := range g.held {
+			delete(g.held, key)
+			break
+		}
+	}
+	g.held[heldKey(tx.From, tx.Nonce)] = tx
+}
+
+// takeHeld returns and forgets the held transaction of from with nonce, or nil.
+func (g *Gossip) takeHeld(from string, nonce uint64) *Transaction {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	key := heldKey(from, nonce)
+	tx := g.held[key]
+	delete(g.held, key)
+	return tx
+}
+
+// markKnown records that peer has item and reports whether that was news.
+func (g *Gossip) markKnown(peer string, item InvItem) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	known := g.known[peer]
+	if known == nil {
+		known = newInventorySet(maxKnownInventory)
+		g.known[peer] = known
+	}
+	return known.add(item.key())
+}
+
+// prune forgets what is known about the peers not in addresses, those that left the table.
+func (g *Gossip) prune(addresses []string) {
+	keep := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		keep[address] = true
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for peer := range g.known {
+		if !keep[peer] {
+			delete(g.known, peer)
+		}
+	}
+}
+
+// request reports whether item should be fetched, that is it was neither processed
+// nor requested yet, and marks it as requested.
+func (g *Gossip) request(item InvItem) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	key := item.key()
+	if g.processed.keys[key] || g.inFlight[key] {
+		return false
+	}
+	g.inFlight[key] = true
+	return true
+}
+
+// done marks a requested item as no longer being fetched.
+func (g *Gossip) done(item InvItem) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	delete(g.inFlight, item.key())
+}
+
+// markProcessed records that item was received and handled, valid or not.
+func (g *Gossip) markProcessed(item InvItem) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.processed.add(item.key())
+}
+
+// hasInventory reports whether the node already has item.
+func (node *Node) hasInventory(item InvItem) bool {
+	switch item.Type {
+	case InvBlock:
+		node.BlockchainMutex.Lock()
+		defer node.BlockchainMutex.Unlock()
+		return node.Blockchain.GetBlock(item.Hash) != nil
+	case InvTx:
+		return node.Blockchain.Mempool.Contains(item.Hash)
+	}
+	return false
+}
+
+// announce sends an inv for item to the outbound peers not known to have it.
+func (node *Node) announce(item InvItem) {
+	node.gossip.markProcessed(item)
+
+	capability := CapabilityBlocks
+	if item.Type == InvTx {
+		capability = CapabilityMempool
+	}
+	message := InvMessage{From: node.Address, Items: []InvItem{item}}
+	for _, peer := range node.Peers.Outbound() {
+		if node.Conns.State(peer) == ConnBackoff || !node.gossip.markKnown(peer, item) {
+			continue
+		}
+		go func(peer string) {
+			var reply string
+			if err := node.Conns.Call(peer, "Node.Inv", message, &reply, capability); err != nil {
+				log.Printf("Error announcing to node %s: %v", peer, err)
+			}
+		}(peer)
+	}
+}
+
+// Inv receives an announcement and requests the items this node lacks from the sender.
+// From is chosen by the caller, so announcements naming a peer outside the table are
+// ignored: they would make us remember, and dial, any address.
+func (node *Node) Inv(message InvMessage, reply *string) error {
+	if !node.Peers.Known(message.From) {
+		*reply = "Announcement from an unknown peer ignored"
+		return nil
+	}
+	if len(message.Items) > maxInvItems {
+		message.Items = message.Items[:maxInvItems]
+	}
+
+	var wanted []InvItem
+	for _, item := range message.Items {
+		node.gossip.markKnown(message.From, item)
+		if node.hasInventory(item) || !node.gossip.request(item) {
+			continue
+		}
+		wanted = append(wanted, item)
+	}
+	if len(wanted) > 0 {
+		go node.fetch(message.From, wanted)
+	}
+	*reply = fmt.Sprintf("Requested %d items", len(wanted))
+	return nil
+}
+
+// fetch requests items from peer and processes them, relaying the accepted ones.
+func (node *Node) fetch(peer string, items []InvItem) {
+	defer func() {
+		for _, item := range items {
+			node.gossip.done(item)
+		}
+	}()
+
+	var data GetDataReply
+	if err := node.Conns.Call(peer, "Node.GetData", items, &data); err != nil {
+		log.Printf("Error fetching announced items from node %s: %v", peer, err)
+		return
+	}
+
+	for _, block := range data.Blocks {
+		if !isRequested(items, InvBlock, block.Hash) {
+			continue
+		}
+		added, err := node.AddBlockToBlockchain(block)
+		node.gossip.markProcessed(InvItem{Type: InvBlock, Hash: block.Hash})
+		if err != nil {
+			log.Printf("Received invalid block from node %s, rejecting: %v", peer, err)
+			if score, reason := misbehaviorScore(err); score > 0 {
+				node.Conns.Misbehaving(peer, score, reason)
+			}
+			continue
+		}
+		if added {
+			node.announce(InvItem{Type: InvBlock, Hash: block.Hash})
+		} else {
+			go node.SyncWithNetwork() // The block is on a branch we lack, fetch it headers first
+		}
+	}
+	sort.SliceStable(data.Transactions, func(i, j int) bool { return data.Transactions[i].Nonce < data.Transactions[j].Nonce })
+	for _, tx := range data.Transactions {
+		if !isRequested(items, InvTx, tx.ID) {
+			continue
+		}
+		if !tx.IsValid() {
+			node.gossip.markProcessed(InvItem{Type: InvTx, Hash: tx.ID})
+			log.Printf("Received malformed transaction %x from node %s", tx.ID, peer)
+			node.Conns.Misbehaving(peer, scoreMalformedTransaction, ErrMalformedTransaction.Error())
+			continue
+		}
+		node.acceptRelayed(peer, tx)
+	}
+}
+
+// acceptRelayed adds a relayed transaction to the mempool, followed by the held
+// transactions of its sender it unblocks. A transaction ahead of its sender's next nonce,
+// as when getdata replies arrive out of order, is held instead and not marked processed,
+// so it is accepted once the gap is filled or fetched again if announced again.
+func (node *Node) acceptRelayed(peer string, tx *Transaction) {
+	for tx != nil {
+		err := node.acceptTransaction(tx)
+		if errors.Is(err, ErrNonceGap) {
+			node.gossip.hold(tx)
+			return
+		}
+		if err != nil {
+			node.gossip.markProcessed(InvItem{Type: InvTx, Hash: tx.ID})
+			log.Printf("Transaction %x from node %s rejected: %v", tx.ID, peer, err)
+			return
+		}
+		tx = node.gossip.takeHeld(tx.From, tx.Nonce+1)
+	}
+}
+
+func isRequested(items []InvItem, invType InvType, hash []byte) bool {
+	for _, item := range items {
+		if item.Type == invType && bytes.Equal(item.Hash, hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetData returns the requested blocks and pending transactions this node has.
+func (node *Node) GetData(items []InvItem, reply *GetDataReply) error {
+	if len(items) > maxInvItems {
+		items = items[:maxInvItems]
+	}
+
+	node.BlockchainMutex.Lock()
+	defer node.BlockchainMutex.Unlock()
+
+	for _, item := range items {
+		switch item.Type {
+		case InvBlock:
+			if block := node.Blockchain.GetBlock(item.Hash); block != nil {
+				reply.Blocks = append(reply.Blocks, block)
+			}
+		case InvTx:
+			if tx := node.Blockchain.Mempool.Get(item.Hash); tx != nil {
+				reply.Transactions = append(reply.Transactions, tx)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGossipRelaysThroughPeers(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+	genesis := NewBlock([]*Transaction{NewTransaction("", alice.Address(), 100)}, []byte{})
+	chain := func() *Blockchain {
+		blockchain := &Blockchain{Mempool: NewMempool()}
+		if err := blockchain.ReplaceBlocks([]*Block{genesis}); err != nil {
+			t.Fatalf("ReplaceBlocks() failed with error: %v", err)
+		}
+		return blockchain
+	}
+
+	// a and c only reach each other through b
+	a := startTestNode(t, chain())
+	b := startTestNode(t, chain())
+	c := startTestNode(t, chain())
+	a.Peers.Add(b.Address)
+	b.Peers.Add(a.Address, c.Address)
+	c.Peers.Add(b.Address)
+	for _, node := range []*Node{a, b, c} {
+		defer node.Conns.Close()
+	}
+
+	tx, _ := NewSignedTransaction(alice, bob.Address(), 10, 0)
+	var reply string
+	a.ReceiveTransaction(tx, &reply)
+	if !waitFor(func() bool { return c.Blockchain.Mempool.Contains(tx.ID) }) {
+		t.Fatal("Inv() failed, the transaction did not reach the node two hops away")
+	}
+
+	block := NewBlock([]*Transaction{tx}, genesis.Hash)
+	if added, err := a.AddBlockToBlockchain(block); !added || err != nil {
+		t.Fatalf("AddBlockToBlockchain() failed with error: %v", err)
+	}
+	a.BroadcastNewBlock(block, &reply)
+	if !waitFor(func() bool { return c.hasInventory(InvItem{Type: InvBlock, Hash: block.Hash}) }) {
+		t.Fatal("Inv() failed, the block did not reach the node two hops away")
+	}
+	if !waitFor(func() bool { return c.Blockchain.Mempool.Len() == 0 }) {
+		t.Error("Inv() failed, the relayed block did not clear the mempool")
+	}
+
+	// b learned both items from a, so it must not announce them back
+	for _, item := range []InvItem{{Type: InvTx, Hash: tx.ID}, {Type: InvBlock, Hash: block.Hash}} {
+		if b.gossip.markKnown(a.Address, item) {
+			t.Errorf("Inv() failed, item %x was not marked as known by its sender", item.Hash)
+		}
+	}
+}
+
+func TestInventorySetForgetsOldest(t *testing.T) {
+	set := newInventorySet(2)
+	if !set.add("a") || !set.add("b") || set.add("a") {
+		t.Fatal("add() failed, expected only new keys to be reported")
+	}
+	set.add("c")
+	if set.keys["a"] || !set.keys["b"] || !set.keys["c"] {
+		t.Errorf("add() failed, expected the oldest key to be forgotten, got %v", set.order)
+	}
+}
+
+func TestInvIgnoresUnknownPeers(t *testing.T) {
+	node := NewNode("127.0.0.1:4400", newTestChain(t))
+	node.Peers.Add("127.0.0.1:4401")
+
+	item := InvItem{Type: InvTx, Hash: []byte("unknown")}
+	var reply string
+	node.Inv(InvMessage{From: "127.0.0.1:4402", Items: []InvItem{item}}, &reply)
+	node.Inv(InvMessage{From: "127.0.0.1:4401", Items: []InvItem{item}}, &reply)
+	if len(node.gossip.known) != 1 || node.gossip.known["127.0.0.1:4401"] == nil {
+		t.Errorf("Inv() failed, expected inventory of the known peer only, got %d sets", len(node.gossip.known))
+	}
+
+	node.gossip.prune(nil)
+	if len(node.gossip.known) != 0 {
+		t.Error("prune() failed, inventory of a peer that left the table was kept")
+	}
+}
+
+func TestBroadcastNewBlockRefusesUnknownBlocks(t *testing.T) {
+	node := NewNode("127.0.0.1:4401", newTestChain(t))
+	block := NewBlock([]*Transaction{}, node.Blockchain.GetLatestBlock().Hash)
+
+	var reply string
+	if err := node.BroadcastNewBlock(block, &reply); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("BroadcastNewBlock() failed, expected ErrBlockNotFound for a block the node does not hold, got %v", err)
+	}
+}
+
+func TestRelayedTransactionsWaitForEarlierNonce(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+	node := NewNode("127.0.0.1:4901", newTestChain(t, alice.Address()))
+
+	first, _ := NewSignedTransaction(alice, bob.Address(), 10, 0)
+	second, _ := NewSignedTransaction(alice, bob.Address(), 10, 1)
+	node.acceptRelayed("127.0.0.1:4902", second)
+	if node.Blockchain.Mempool.Len() != 0 {
+		t.Fatal("acceptRelayed() failed, accepted a transaction skipping a nonce")
+	}
+	if !node.gossip.request(InvItem{Type: InvTx, Hash: second.ID}) {
+		t.Error("acceptRelayed() failed, a held transaction can no longer be fetched")
+	}
+	node.gossip.done(InvItem{Type: InvTx, Hash: second.ID})
+
+	node.acceptRelayed("127.0.0.1:4902", first)
+	if !node.Blockchain.Mempool.Contains(first.ID) || !node.Blockchain.Mempool.Contains(second.ID) {
+		t.Errorf("acceptRelayed() failed, expected both transactions once the gap was filled, got %d", node.Blockchain.Mempool.Len())
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/rpc"
+	"strings"
+)
+
+const (
+	ProtocolVersion    = 1 // Version of the node-to-node protocol spoken by this build
+	MinProtocolVersion = 1 // Oldest protocol version still accepted from peers
+)
+
+// Capabilities a node advertises in its handshake.
+const (
+	CapabilityBlocks       = "blocks"        // Serves its chain and accepts new blocks
+	CapabilityMempool      = "mempool"       // Accepts transactions into its mempool
+	CapabilityPeers        = "peers"         // Exchanges peer addresses
+	CapabilityMerkleProofs = "merkle-proofs" // Serves Merkle proofs of transactions
+)
+
+var nodeCapabilities = []string{CapabilityBlocks, CapabilityMempool, CapabilityPeers, CapabilityMerkleProofs}
+
+var (
+	ErrIncompatibleVersion = errors.New("incompatible protocol version")
+	ErrGenesisMismatch     = errors.New("peer is on a different genesis block")
+	ErrMissingCapability   = errors.New("peer lacks a required capability")
+)
+
+// Handshake is exchanged when a connection to a peer is opened, so both sides know
+// they speak the same protocol on the same chain before anything else is sent.
+type Handshake struct {
+	Version      int
+	GenesisHash  []byte
+	BestHeight   int
+	Capabilities []string
+	Address      string // Listening address of the sender, empty for clients
+	RPCAddress   string // Where the sender serves its JSON-RPC API and event stream, empty if it does not
+}
+
+// Handshake describes this blockchain for a handshake sent from address.
+func (bc *Blockchain) Handshake(address string, capabilities []string) Handshake {
+	handshake := Handshake{
+		Version:      ProtocolVersion,
+		BestHeight:   len(bc.Blocks) - 1,
+		Capabilities: capabilities,
+		Address:      address,
+	}
+	if len(bc.Blocks) > 0 {
+		handshake.GenesisHash = bc.Blocks[0].Hash
+	}
+	return handshake
+}
+
+// HasCapability reports whether the peer advertised capability.
+func (h *Handshake) HasCapability(capability string) bool {
+	for _, c := range h.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// require returns an error naming the first required capability the peer lacks. A
+// missing handshake, from a client that made none, requires nothing.
+func (h *Handshake) require(required []string) error {
+	if h == nil {
+		return nil
+	}
+	for _, capability := range required {
+		if !h.HasCapability(capability) {
+			return fmt.Errorf("%w: %s", ErrMissingCapability, capability)
+		}
+	}
+	return nil
+}
+
+// checkHandshake returns an error if the remote peer cannot talk to us.
+func checkHandshake(local, remote *Handshake) error {
+	if remote.Version < MinProtocolVersion {
+		return fmt.Errorf("%w: peer speaks version %d, at least %d required", ErrIncompatibleVersion, remote.Version, MinProtocolVersion)
+	}
+	if !bytes.Equal(local.GenesisHash, remote.GenesisHash) {
+		return fmt.Errorf("%w: %x, ours is %x", ErrGenesisMismatch, remote.GenesisHash, local.GenesisHash)
+	}
+	return nil
+}
+
+// localHandshake describes this node for a handshake.
+func (node *Node) localHandshake() Handshake {
+	node.BlockchainMutex.Lock()
+	defer node.BlockchainMutex.Unlock()
+
+	handshake := node.Blockchain.Handshake(node.Address, nodeCapabilities)
+	handshake.RPCAddress = node.JSONRPCAddress
+	return handshake
+}
+
+// Handshake replies with our own handshake, so the caller can check it is on our chain.
+// A caller on another genesis block or an unsupported protocol version is refused with
+// an error. The peer table is left alone: the address in the request is whatever the
+// caller claims, so only the peers we dial ourselves are added or rejected.
+func (node *Node) Handshake(request Handshake, reply *Handshake) error {
+	*reply = node.localHandshake()
+	if err := checkHandshake(reply, &request); err != nil {
+		log.Printf("Refusing handshake from %s: %v", request.Address, err)
+		return err
+	}
+	return nil
+}
+
+// asHandshakeError returns the typed form of a handshake refused by a remote node, whose
+// errors arrive as rpc.ServerError strings, or nil if err is no such refusal.
+func asHandshakeError(err error) error {
+	var serverErr rpc.ServerError
+	if !errors.As(err, &serverErr) {
+		return nil
+	}
+	for _, refusal := range []error{ErrIncompatibleVersion, ErrGenesisMismatch} {
+		if strings.HasPrefix(string(serverErr), refusal.Error()) {
+			return fmt.Errorf("%w: refused by the peer: %s", refusal, strings.TrimPrefix(string(serverErr), refusal.Error()+": "))
+		}
+	}
+	return nil
+}
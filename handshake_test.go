@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHandshakeRejectsOtherGenesis(t *testing.T) {
+	ours := newTestChain(t)
+	remote := startTestNode(t, newTestChain(t))
+
+	local := NewNode("127.0.0.1:4300", ours)
+	local.Peers.Add(remote.Address)
+	var addresses []string
+	if err := local.Conns.Call(remote.Address, "Node.GetPeers", "test", &addresses, CapabilityBlocks); !errors.Is(err, ErrGenesisMismatch) {
+		t.Fatalf("Call() failed, expected ErrGenesisMismatch, got %v", err)
+	}
+	if len(local.Peers.Addresses()) != 0 || local.Peers.Add(remote.Address) != 0 {
+		t.Error("Call() failed, a peer on another genesis should be rejected for good")
+	}
+	if len(remote.Peers.Addresses()) != 0 {
+		t.Error("Handshake() failed, the remote node accepted a peer on another genesis")
+	}
+}
+
+func TestHandshakeOnSameChain(t *testing.T) {
+	ours := newTestChain(t)
+	remote := startTestNode(t, &Blockchain{Mempool: NewMempool()})
+	if err := remote.Blockchain.ReplaceBlocks(ours.Blocks); err != nil {
+		t.Fatalf("ReplaceBlocks() failed with error: %v", err)
+	}
+
+	local := NewNode("127.0.0.1:4301", ours)
+	local.Peers.Add(remote.Address)
+	defer local.Conns.Close()
+	var addresses []string
+	if err := local.Conns.Call(remote.Address, "Node.GetPeers", "test", &addresses, CapabilityBlocks, CapabilityMempool); err != nil {
+		t.Fatalf("Call() failed with error: %v", err)
+	}
+
+	peers := local.Peers.Peers()
+	if len(peers) != 1 || peers[0].Version != ProtocolVersion || len(peers[0].Capabilities) != len(nodeCapabilities) {
+		t.Errorf("Call() failed, the peer's handshake was not recorded: %+v", peers)
+	}
+	if len(remote.Peers.Addresses()) != 0 {
+		t.Error("Handshake() failed, the remote node added the unverified address of its caller")
+	}
+
+	if err := local.Conns.Call(remote.Address, "Node.GetPeers", "test", &addresses, "unknown"); !errors.Is(err, ErrMissingCapability) {
+		t.Errorf("Call() failed, expected ErrMissingCapability, got %v", err)
+	}
+}
+
+func TestHandshakeLeavesClaimedAddressAlone(t *testing.T) {
+	seed := "127.0.0.1:4302"
+	node := NewNode("127.0.0.1:4303", newTestChain(t))
+	node.UsePeers(NewPeerManager(node.Address, "", []string{seed}))
+
+	forged := newTestChain(t).Handshake(seed, nodeCapabilities)
+	var reply Handshake
+	if err := node.Handshake(forged, &reply); !errors.Is(err, ErrGenesisMismatch) {
+		t.Fatalf("Handshake() failed, expected ErrGenesisMismatch, got %v", err)
+	}
+	if !contains(node.Peers.Addresses(), seed) || node.Peers.Add(seed) != 0 {
+		t.Error("Handshake() failed, a caller naming the seed got it rejected")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+// newTestChain returns an in-memory chain whose genesis block pays 100 coins to each of
+// addresses, or to a new wallet when none are given.
+func newTestChain(t *testing.T, addresses ...string) *Blockchain {
+	if len(addresses) == 0 {
+		addresses = []string{NewWallet().Address()}
+	}
+	var txs []*Transaction
+	for _, address := range addresses {
+		txs = append(txs, NewTransaction("", address, 100))
+	}
+	blockchain := &Blockchain{Mempool: NewMempool()}
+	if err := blockchain.ReplaceBlocks([]*Block{NewBlock(txs, []byte{})}); err != nil {
+		t.Fatalf("ReplaceBlocks() failed with error: %v", err)
+	}
+	return blockchain
+}
+
+// startTestNode serves the RPCs of a node on a free local port.
+func startTestNode(t *testing.T, blockchain *Blockchain) *Node {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed with error: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	node := NewNode(listener.Addr().String(), blockchain)
+	server := rpc.NewServer()
+	server.Register(node)
+	go node.Serve(listener, server)
+	return node
+}
+
+// waitFor polls condition until it holds or a few seconds have passed.
+func waitFor(condition func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return condition()
+}
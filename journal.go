@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const journalFileName = "mempool.journal" // Pending transactions, one JSON record per line
+
+// journalEntry is one line of the mempool journal.
+type journalEntry struct {
+	Tx    *Transaction
+	Added time.Time // When the transaction entered the mempool
+}
+
+// MempoolJournal keeps a node's pending transactions in its data directory so they
+// survive a restart. Every accepted transaction is appended and synced; the file is
+// rewritten with only the still pending ones whenever the mempool is revalidated.
+type MempoolJournal struct {
+	mutex sync.Mutex
+	path  string
+	file  *os.File
+}
+
+// OpenMempoolJournal opens or creates the mempool journal in dir.
+func OpenMempoolJournal(dir string) (*MempoolJournal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, journalFileName)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &MempoolJournal{path: path, file: file}, nil
+}
+
+// Load reads every entry in the journal. A damaged last line, left by a crash while
+// appending, ends the journal.
+func (j *MempoolJournal) Load() ([]journalEntry, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	file, err := os.Open(j.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordLen)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Tx == nil {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Append records a transaction accepted into the mempool.
+func (j *MempoolJournal) Append(tx *Transaction, added time.Time) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	line, err := json.Marshal(journalEntry{Tx: tx, Added: added})
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Rewrite replaces the journal with the given entries. The new journal is written to a
+// temporary file first, so a crash leaves either the old or the new one.
+func (j *MempoolJournal) Rewrite(entries []journalEntry) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	tmpPath := j.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(j.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	return nil
+}
+
+// Close closes the journal file.
+func (j *MempoolJournal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.file.Close()
+}
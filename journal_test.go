@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestMempoolSurvivesRestart(t *testing.T) {
+	defer func(maturity int) { CoinbaseMaturity = maturity }(CoinbaseMaturity)
+	CoinbaseMaturity = 1
+
+	dataDir := t.TempDir()
+	alice := NewWallet()
+	bob := NewWallet()
+
+	blockchain := NewBlockchain(dataDir)
+	t.Cleanup(func() { blockchain.Close() })
+	coinbase := blockchain.NewCoinbase(alice.Address(), nil)
+	if err := blockchain.AddBlock(NewBlock([]*Transaction{coinbase}, blockchain.GetLatestBlock().Hash)); err != nil {
+		t.Fatalf("AddBlock() failed with error: %v", err)
+	}
+
+	tx, _ := NewSignedTransaction(alice, bob.Address(), 10, 0)
+	if err := blockchain.AddTransactionToMempool(tx); err != nil {
+		t.Fatalf("AddTransactionToMempool() failed with error: %v", err)
+	}
+
+	restarted := NewBlockchain(dataDir)
+	t.Cleanup(func() { restarted.Close() })
+	if restarted.Mempool.Len() != 1 || !restarted.Mempool.Contains(tx.ID) {
+		t.Fatal("NewBlockchain() failed, the pending transaction was not restored")
+	}
+
+	// Once mined the transaction is dropped from the journal
+	if err := restarted.AddBlock(NewBlock([]*Transaction{tx}, restarted.GetLatestBlock().Hash)); err != nil {
+		t.Fatalf("AddBlock() failed with error: %v", err)
+	}
+	again := NewBlockchain(dataDir)
+	t.Cleanup(func() { again.Close() })
+	if again.Mempool.Len() != 0 {
+		t.Errorf("NewBlockchain() failed, expected an empty mempool after mining, got %d", again.Mempool.Len())
+	}
+}
+
+func TestEvictedTransactionsLeaveTheJournal(t *testing.T) {
+	defer func(maturity, size int) {
+		CoinbaseMaturity, MaxMempoolTransactions = maturity, size
+	}(CoinbaseMaturity, MaxMempoolTransactions)
+	CoinbaseMaturity = 1
+	MaxMempoolTransactions = 1
+
+	dataDir := t.TempDir()
+	alice := NewWallet()
+	bob := NewWallet()
+
+	blockchain := NewBlockchain(dataDir)
+	t.Cleanup(func() { blockchain.Close() })
+	for _, miner := range []*Wallet{alice, bob} {
+		coinbase := blockchain.NewCoinbase(miner.Address(), nil)
+		if err := blockchain.AddBlock(NewBlock([]*Transaction{coinbase}, blockchain.GetLatestBlock().Hash)); err != nil {
+			t.Fatalf("AddBlock() failed with error: %v", err)
+		}
+	}
+
+	cheap, _ := NewSignedTransactionWithFee(alice, bob.Address(), 10, 1, 0)
+	if err := blockchain.AddTransactionToMempool(cheap); err != nil {
+		t.Fatalf("AddTransactionToMempool() failed with error: %v", err)
+	}
+	dear, _ := NewSignedTransactionWithFee(bob, alice.Address(), 10, 10, 0)
+	if err := blockchain.AddTransactionToMempool(dear); err != nil {
+		t.Fatalf("AddTransactionToMempool() failed with error: %v", err)
+	}
+
+	// With room for both, only the journal decides what comes back
+	MaxMempoolTransactions = 2
+	restarted := NewBlockchain(dataDir)
+	t.Cleanup(func() { restarted.Close() })
+	if restarted.Mempool.Contains(cheap.ID) || !restarted.Mempool.Contains(dear.ID) {
+		t.Error("NewBlockchain() failed, expected only the transaction that was not evicted to be restored")
+	}
+}
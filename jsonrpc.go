@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+const maxJSONRPCRequestSize = 1 << 20 // Largest HTTP body accepted by the JSON-RPC endpoint
+
+// JSON-RPC error codes. The codes from -32700 to -32600 are defined by the JSON-RPC 2.0
+// specification, the others by this node.
+const (
+	codeParseError          = -32700 // Body is not JSON
+	codeInvalidRequest      = -32600 // Not a JSON-RPC 2.0 request
+	codeMethodNotFound      = -32601
+	codeInvalidParams       = -32602
+	codeInternalError       = -32603
+	codeNotFound            = -32000 // No block with the requested hash or height
+	codeInvalidTransaction  = -32001 // Transaction with a bad signature or ID
+	codeTransactionRejected = -32002 // Valid transaction the mempool refused: replayed, overspending, fee too low
+	codeRateLimited         = -32003
+)
+
+// jsonRPCRequest is a JSON-RPC 2.0 request. Requests without an ID are notifications,
+// which get no response.
+type jsonRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// JSONRPCError is the error member of a failed JSON-RPC response.
+type JSONRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("JSON-RPC error %d: %s", e.Code, e.Message)
+}
+
+// jsonRPCMethod decodes the params of a call and answers it through the node's RPCs.
+type jsonRPCMethod func(node *Node, params json.RawMessage) (interface{}, error)
+
+// jsonRPCMethods are the methods of the JSON-RPC endpoint. Byte fields, such as hashes,
+// are base64 strings, as in the JSON encoding of blocks and transactions.
+var jsonRPCMethods = map[string]jsonRPCMethod{
+	"sendTransaction": func(node *Node, params json.RawMessage) (interface{}, error) {
+		var tx Transaction
+		if err := decodeParams(params, []string{"transaction"}, &tx); err != nil {
+			return nil, err
+		}
+		if !tx.IsValid() {
+			return nil, ErrMalformedTransaction
+		}
+		if err := node.acceptTransaction(&tx); err != nil {
+			return nil, &JSONRPCError{Code: codeTransactionRejected, Message: err.Error()}
+		}
+		return tx.ID, nil
+	},
+	"getBlockByHash": func(node *Node, params json.RawMessage) (interface{}, error) {
+		var hash []byte
+		if err := decodeParams(params, []string{"hash"}, &hash); err != nil {
+			return nil, err
+		}
+		var reply BlockInfo
+		return &reply, node.GetBlockByHash(hash, &reply)
+	},
+	"getBlockByHeight": func(node *Node, params json.RawMessage) (interface{}, error) {
+		var height int
+		if err := decodeParams(params, []string{"height"}, &height); err != nil {
+			return nil, err
+		}
+		var reply BlockInfo
+		return &reply, node.GetBlockByHeight(height, &reply)
+	},
+	"getChainTip": func(node *Node, params json.RawMessage) (interface{}, error) {
+		var reply ChainTip
+		return &reply, node.GetChainTip("", &reply)
+	},
+	"getBalance": func(node *Node, params json.RawMessage) (interface{}, error) {
+		var address string
+		if err := decodeParams(params, []string{"address"}, &address); err != nil {
+			return nil, err
+		}
+		var reply int
+		if err := node.GetBalance(address, &reply); err != nil {
+			return nil, err
+		}
+		return reply, nil
+	},
+	"getMempool": func(node *Node, params json.RawMessage) (interface{}, error) {
+		var reply []*Transaction
+		if err := node.GetMempool("", &reply); err != nil {
+			return nil, err
+		}
+		return reply, nil
+	},
+	"getPeers": func(node *Node, params json.RawMessage) (interface{}, error) {
+		var reply []string
+		if err := node.GetPeers("", &reply); err != nil {
+			return nil, err
+		}
+		return reply, nil
+	},
+}
+
+// decodeParams decodes params given either by position, as an array, or by name, as an
+// object with the given names, into targets. Missing params keep their zero value.
+func decodeParams(params json.RawMessage, names []string, targets ...interface{}) error {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 {
+		return nil
+	}
+
+	var fields []json.RawMessage
+	if params[0] == '[' {
+		if err := json.Unmarshal(params, &fields); err != nil {
+			return invalidParams(err)
+		}
+		if len(fields) > len(targets) {
+			return invalidParams(fmt.Errorf("expected at most %d params, got %d", len(targets), len(fields)))
+		}
+	} else {
+		var named map[string]json.RawMessage
+		if err := json.Unmarshal(params, &named); err != nil {
+			return invalidParams(err)
+		}
+		for _, name := range names {
+			fields = append(fields, named[name])
+		}
+	}
+
+	for i, field := range fields {
+		if field == nil {
+			continue
+		}
+		if err := json.Unmarshal(field, targets[i]); err != nil {
+			return invalidParams(fmt.Errorf("%s: %v", names[i], err))
+		}
+	}
+	return nil
+}
+
+func invalidParams(err error) *JSONRPCError {
+	return &JSONRPCError{Code: codeInvalidParams, Message: "invalid params: " + err.Error()}
+}
+
+// asJSONRPCError maps an error of the node's RPCs to its JSON-RPC code.
+func asJSONRPCError(err error) *JSONRPCError {
+	var rpcErr *JSONRPCError
+	switch {
+	case errors.As(err, &rpcErr):
+		return rpcErr
+	case errors.Is(err, ErrBlockNotFound):
+		return &JSONRPCError{Code: codeNotFound, Message: err.Error()}
+	case errors.Is(err, ErrMalformedTransaction):
+		return &JSONRPCError{Code: codeInvalidTransaction, Message: err.Error()}
+	default:
+		return &JSONRPCError{Code: codeInternalError, Message: err.Error()}
+	}
+}
+
+// JSONRPCHandler serves the node's JSON-RPC 2.0 API over HTTP POST, single and batch
+// requests alike, for tools that do not speak Go's net/rpc. Calls count against the
+// caller's rate limits and banned peers are refused, as on the peer port.
+func (node *Node) JSONRPCHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "JSON-RPC requests must be POSTed", http.StatusMethodNotAllowed)
+			return
+		}
+		peer := r.RemoteAddr
+		if node.Guard.IsBanned(peer) {
+			http.Error(w, ErrPeerBanned.Error(), http.StatusForbidden)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONRPCRequestSize))
+		if err != nil {
+			writeJSON(w, jsonRPCResponse{JSONRPC: "2.0", Error: &JSONRPCError{Code: codeParseError, Message: err.Error()}})
+			return
+		}
+		body = bytes.TrimSpace(body)
+
+		if len(body) > 0 && body[0] == '[' {
+			var requests []json.RawMessage
+			if err := json.Unmarshal(body, &requests); err != nil {
+				writeJSON(w, jsonRPCResponse{JSONRPC: "2.0", Error: &JSONRPCError{Code: codeParseError, Message: err.Error()}})
+				return
+			}
+			if len(requests) == 0 {
+				writeJSON(w, jsonRPCResponse{JSONRPC: "2.0", Error: &JSONRPCError{Code: codeInvalidRequest, Message: "empty batch"}})
+				return
+			}
+			var responses []jsonRPCResponse
+			for _, request := range requests {
+				if response := node.handleJSONRPC(peer, request); response != nil {
+					responses = append(responses, *response)
+				}
+			}
+			if len(responses) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			writeJSON(w, responses)
+			return
+		}
+
+		response := node.handleJSONRPC(peer, body)
+		if response == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, response)
+	})
+}
+
+// handleJSONRPC answers one request, returning nil for a notification.
+func (node *Node) handleJSONRPC(peer string, body []byte) *jsonRPCResponse {
+	var request jsonRPCRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		var syntaxErr *json.SyntaxError
+		code := codeInvalidRequest
+		if errors.As(err, &syntaxErr) {
+			code = codeParseError
+		}
+		return &jsonRPCResponse{JSONRPC: "2.0", Error: &JSONRPCError{Code: code, Message: err.Error()}, ID: json.RawMessage("null")}
+	}
+
+	response := &jsonRPCResponse{JSONRPC: "2.0", ID: request.ID}
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		response.Error = &JSONRPCError{Code: codeInvalidRequest, Message: `expected "jsonrpc": "2.0" and a method`}
+	} else if method, ok := jsonRPCMethods[request.Method]; !ok {
+		response.Error = &JSONRPCError{Code: codeMethodNotFound, Message: "method not found: " + request.Method}
+	} else if !node.Guard.allow(peer, "jsonrpc."+request.Method) {
+		response.Error = &JSONRPCError{Code: codeRateLimited, Message: ErrRateLimited.Error()}
+		node.Guard.Misbehaving(peer, scoreFlooding, "flooding jsonrpc."+request.Method)
+	} else if result, err := method(node, request.Params); err != nil {
+		response.Error = asJSONRPCError(err)
+	} else {
+		response.Result = result
+	}
+
+	if request.ID == nil && request.JSONRPC == "2.0" && request.Method != "" {
+		return nil // Notifications get no response, not even an error
+	}
+	if response.ID == nil {
+		response.ID = json.RawMessage("null")
+	}
+	return response
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing JSON-RPC response: %v", err)
+	}
+}
+
+// ServeJSONRPC serves the JSON-RPC API, and the event stream at /events, on address
+// until the listener fails.
+func (node *Node) ServeJSONRPC(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/", node.JSONRPCHandler())
+	mux.Handle("/events", node.EventsHandler())
+	log.Printf("JSON-RPC API listening at http://%s/, events at http://%s/events", address, address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		log.Printf("JSON-RPC API stopped: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// callJSONRPC posts body to the JSON-RPC endpoint of server and decodes the response.
+func callJSONRPC(t *testing.T, server *httptest.Server, body string, response interface{}) {
+	resp, err := http.Post(server.URL, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("Post() failed with error: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		t.Fatalf("Decode() failed with error: %v", err)
+	}
+}
+
+type testJSONRPCResponse struct {
+	Result json.RawMessage
+	Error  *JSONRPCError
+	ID     json.RawMessage
+}
+
+func TestJSONRPCQueries(t *testing.T) {
+	alice := NewWallet()
+	blockchain := newTestChain(t, alice.Address())
+	genesis := blockchain.GetLatestBlock()
+	node := NewNode("127.0.0.1:4700", blockchain)
+	server := httptest.NewServer(node.JSONRPCHandler())
+	defer server.Close()
+
+	var response testJSONRPCResponse
+	callJSONRPC(t, server, `{"jsonrpc": "2.0", "method": "getBalance", "params": ["`+alice.Address()+`"], "id": 1}`, &response)
+	if response.Error != nil || string(response.Result) != "100" || string(response.ID) != "1" {
+		t.Errorf("getBalance failed, got %s with error %v", response.Result, response.Error)
+	}
+
+	var tip ChainTip
+	response = testJSONRPCResponse{}
+	callJSONRPC(t, server, `{"jsonrpc": "2.0", "method": "getChainTip", "id": "tip"}`, &response)
+	if err := json.Unmarshal(response.Result, &tip); err != nil || tip.Height != 0 || !bytes.Equal(tip.Header.Hash, genesis.Hash) {
+		t.Errorf("getChainTip failed, got %s with error %v", response.Result, response.Error)
+	}
+
+	hash, _ := json.Marshal(genesis.Hash)
+	var info BlockInfo
+	response = testJSONRPCResponse{}
+	callJSONRPC(t, server, `{"jsonrpc": "2.0", "method": "getBlockByHash", "params": {"hash": `+string(hash)+`}, "id": 2}`, &response)
+	if err := json.Unmarshal(response.Result, &info); err != nil || info.Height != 0 || !bytes.Equal(info.Block.Hash, genesis.Hash) {
+		t.Errorf("getBlockByHash failed, got %s with error %v", response.Result, response.Error)
+	}
+
+	response = testJSONRPCResponse{}
+	callJSONRPC(t, server, `{"jsonrpc": "2.0", "method": "getBlockByHeight", "params": [5], "id": 3}`, &response)
+	if response.Error == nil || response.Error.Code != codeNotFound {
+		t.Errorf("getBlockByHeight failed, expected code %d, got %v", codeNotFound, response.Error)
+	}
+}
+
+func TestJSONRPCSendTransaction(t *testing.T) {
+	alice := NewWallet()
+	blockchain := newTestChain(t, alice.Address())
+	node := NewNode("127.0.0.1:4701", blockchain)
+	server := httptest.NewServer(node.JSONRPCHandler())
+	defer server.Close()
+
+	tx, err := NewSignedTransaction(alice, NewWallet().Address(), 10, 0)
+	if err != nil {
+		t.Fatalf("NewSignedTransaction() failed with error: %v", err)
+	}
+	encoded, _ := json.Marshal(tx)
+	forged := *tx
+	forged.Amount = 90
+	encodedForged, _ := json.Marshal(&forged)
+
+	var responses []testJSONRPCResponse
+	callJSONRPC(t, server, `[
+		{"jsonrpc": "2.0", "method": "sendTransaction", "params": [`+string(encoded)+`], "id": 1},
+		{"jsonrpc": "2.0", "method": "sendTransaction", "params": [`+string(encodedForged)+`], "id": 2},
+		{"jsonrpc": "2.0", "method": "getMempool"},
+		{"jsonrpc": "2.0", "method": "mine", "id": 3}
+	]`, &responses)
+
+	if len(responses) != 3 {
+		t.Fatalf("batch failed, expected 3 responses without the notification, got %d", len(responses))
+	}
+	if responses[0].Error != nil || !node.Blockchain.Mempool.Contains(tx.ID) {
+		t.Errorf("sendTransaction failed with error %v", responses[0].Error)
+	}
+	if responses[1].Error == nil || responses[1].Error.Code != codeInvalidTransaction {
+		t.Errorf("sendTransaction failed, expected code %d for a forged transaction, got %v", codeInvalidTransaction, responses[1].Error)
+	}
+	if responses[2].Error == nil || responses[2].Error.Code != codeMethodNotFound {
+		t.Errorf("mine failed, expected code %d, got %v", codeMethodNotFound, responses[2].Error)
+	}
+}
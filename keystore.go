@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	keystoreDir  = "keystore" // Directory holding one encrypted key file per address
+	scryptN      = 1 << 15    // scrypt CPU/memory cost
+	scryptR      = 8          // scrypt block size
+	scryptP      = 1          // scrypt parallelization
+	scryptKeyLen = 32         // AES-256 key length
+)
+
+var (
+	ErrKeyNotFound     = errors.New("no key stored for this address")
+	ErrInvalidPassword = errors.New("invalid password")
+)
+
+// encryptedKey is the on-disk representation of a wallet's private key.
+type encryptedKey struct {
+	Address    string // Address the key belongs to, bound to the ciphertext as additional data
+	PublicKey  []byte // Public key, kept in clear so the address can be checked without unlocking
+	KDF        string // Key derivation function used to turn the password into an AES key
+	ScryptN    int
+	ScryptR    int
+	ScryptP    int
+	Salt       []byte
+	Nonce      []byte // AES-GCM nonce
+	Ciphertext []byte // AES-GCM encrypted private scalar
+}
+
+// Keystore stores wallets' private keys encrypted under their owners' passwords
+// and keeps the wallets unlocked by a successful login in memory, reachable only with
+// the random token of the login's session.
+type Keystore struct {
+	dir      string
+	mutex    sync.Mutex
+	sessions map[string]*Wallet // Unlocked wallets by session token
+}
+
+// NewKeystore creates a keystore backed by the given directory.
+func NewKeystore(dir string) *Keystore {
+	return &Keystore{
+		dir:      dir,
+		sessions: make(map[string]*Wallet),
+	}
+}
+
+// keyFile returns the path of the key file for an address.
+func (ks *Keystore) keyFile(address string) string {
+	return filepath.Join(ks.dir, address+".json")
+}
+
+// Store encrypts the wallet's private key with the password and writes it to disk.
+func (ks *Keystore) Store(wallet *Wallet, password string) error {
+	address := wallet.Address()
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := newKeystoreCipher(password, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	plaintext := wallet.PrivateKey.D.FillBytes(make([]byte, 32))
+	key := encryptedKey{
+		Address:    address,
+		PublicKey:  wallet.PublicKey,
+		KDF:        "scrypt",
+		ScryptN:    scryptN,
+		ScryptR:    scryptR,
+		ScryptP:    scryptP,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, []byte(address)),
+	}
+
+	data, err := json.MarshalIndent(key, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ks.dir, 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a half-written key behind
+	tmpFile := ks.keyFile(address) + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, ks.keyFile(address))
+}
+
+// Load reads the encrypted key file for an address without decrypting it.
+func (ks *Keystore) Load(address string) (*encryptedKey, error) {
+	data, err := os.ReadFile(ks.keyFile(address))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+
+	var key encryptedKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	if key.Address != address {
+		return nil, fmt.Errorf("key file for %s belongs to %s", address, key.Address)
+	}
+	return &key, nil
+}
+
+// Unlock decrypts the private key of an address.
+func (ks *Keystore) Unlock(address, password string) (*Wallet, error) {
+	key, err := ks.Load(address)
+	if err != nil {
+		return nil, err
+	}
+	if key.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation function %q", key.KDF)
+	}
+
+	gcm, err := newKeystoreCipher(password, key.Salt, key.ScryptN, key.ScryptR, key.ScryptP)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, key.Nonce, key.Ciphertext, []byte(address))
+	if err != nil {
+		return nil, ErrInvalidPassword
+	}
+
+	wallet, err := walletFromPrivateScalar(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if wallet.Address() != address {
+		return nil, fmt.Errorf("decrypted key does not match address %s", address)
+	}
+
+	return wallet, nil
+}
+
+// StartSession keeps an unlocked wallet in memory and returns the random token it can
+// be reached with until EndSession is called.
+func (ks *Keystore) StartSession(wallet *Wallet) (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	ks.sessions[hex.EncodeToString(token)] = wallet
+	return hex.EncodeToString(token), nil
+}
+
+// Session returns the wallet unlocked for a session token.
+func (ks *Keystore) Session(token string) (*Wallet, bool) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	wallet, ok := ks.sessions[token]
+	return wallet, ok
+}
+
+// EndSession forgets the wallet unlocked for a session token.
+func (ks *Keystore) EndSession(token string) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	delete(ks.sessions, token)
+}
+
+// newKeystoreCipher derives an AES key from the password with scrypt and returns an AES-GCM cipher.
+func newKeystoreCipher(password string, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	derivedKey, err := scrypt.Key([]byte(password), salt, n, r, p, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derivedKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// walletFromPrivateScalar rebuilds a wallet from the private scalar of a P256 key.
+func walletFromPrivateScalar(d []byte) (*Wallet, error) {
+	ecdhKey, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, err
+	}
+	publicKey := ecdhKey.PublicKey().Bytes()[1:] // Drop the 0x04 uncompressed point prefix
+
+	pub, err := parsePubKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	private := ecdsa.PrivateKey{PublicKey: *pub, D: new(big.Int).SetBytes(d)}
+	return &Wallet{private, publicKey}, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestKeystoreStoreUnlockSession(t *testing.T) {
+	keystore := NewKeystore(t.TempDir())
+	wallet := NewWallet()
+	address := wallet.Address()
+
+	if err := keystore.Store(wallet, "secret"); err != nil {
+		t.Fatalf("Store() failed with error: %v", err)
+	}
+
+	if _, err := keystore.Unlock(address, "wrong"); err != ErrInvalidPassword {
+		t.Errorf("Unlock() failed, expected ErrInvalidPassword for a wrong password, got %v", err)
+	}
+
+	unlocked, err := keystore.Unlock(address, "secret")
+	if err != nil {
+		t.Fatalf("Unlock() failed with error: %v", err)
+	}
+	if unlocked.Address() != address || unlocked.PrivateKey.D.Cmp(wallet.PrivateKey.D) != 0 {
+		t.Error("Unlock() failed, the decrypted key does not match the stored wallet")
+	}
+
+	// A wallet restored from disk must still produce valid signatures
+	tx, err := NewSignedTransaction(unlocked, "to", 10, 0)
+	if err != nil || !tx.IsValid() {
+		t.Error("Unlock() failed, the restored wallet cannot sign valid transactions")
+	}
+
+	token, err := keystore.StartSession(unlocked)
+	if err != nil {
+		t.Fatalf("StartSession() failed with error: %v", err)
+	}
+	if session, ok := keystore.Session(token); !ok || session != unlocked {
+		t.Error("Session() failed, the token should reach the unlocked wallet")
+	}
+	if _, ok := keystore.Session(address); ok {
+		t.Error("Session() failed, the address must not reach the unlocked wallet")
+	}
+	keystore.EndSession(token)
+	if _, ok := keystore.Session(token); ok {
+		t.Error("EndSession() failed, the wallet should be forgotten")
+	}
+}
+
+func TestKeystoreMissingKey(t *testing.T) {
+	keystore := NewKeystore(t.TempDir())
+
+	if _, err := keystore.Unlock(NewWallet().Address(), "secret"); err != ErrKeyNotFound {
+		t.Errorf("Unlock() failed, expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestCommittedGenesisUsersUnlock(t *testing.T) {
+	genesis := LoadGenesisBlock()
+	if genesis == nil {
+		t.Fatal("LoadGenesisBlock() failed, no genesis block")
+	}
+	users, err := readUserWalletsFromFile("users.txt")
+	if err != nil || len(users) != len(genesis.Transactions) {
+		t.Fatalf("readUserWalletsFromFile() failed, unlocked %d of %d genesis users with error %v", len(users), len(genesis.Transactions), err)
+	}
+	chain := &Blockchain{Mempool: NewMempool()}
+	if err := chain.ReplaceBlocks([]*Block{genesis}); err != nil {
+		t.Fatalf("ReplaceBlocks() failed with error: %v", err)
+	}
+	for _, user := range users {
+		if chain.GetBalance(user.Address()) == 0 {
+			t.Errorf("genesis block does not fund user %s", user.Address())
+		}
+	}
+}
@@ -1,23 +1,77 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
+	"path/filepath"
 )
 
-func startWalletApp(port string) {
+// transportFlags holds the flags choosing how a process connects to the nodes.
+type transportFlags struct {
+	tls   *bool
+	allow *string
+	key   *string
+}
+
+func addTransportFlags(flags *flag.FlagSet) *transportFlags {
+	return &transportFlags{
+		tls:   flags.Bool("tls", false, "encrypt and authenticate the connections between nodes with TLS"),
+		allow: flags.String("allow", "", "comma separated IDs of the only peers accepted, implies -tls"),
+		key:   flags.String("key", "", "file holding the identity key, created if missing"),
+	}
+}
+
+// transport loads the identity key, from defaultKey unless -key is given, and returns
+// the transport the flags describe. Without -tls or -allow it is nil, plain TCP.
+func (f *transportFlags) transport(defaultKey string) *Transport {
+	allowed := parseSeeds(*f.allow)
+	if !*f.tls && len(allowed) == 0 {
+		return nil
+	}
+	path := *f.key
+	if path == "" {
+		path = defaultKey
+	}
+	identity, err := LoadIdentity(path)
+	if err != nil {
+		log.Fatalf("Failed to load identity key: %v", err)
+	}
+	log.Printf("Identity %s", identity.ID())
+	return NewTransport(identity, true, allowed)
+}
+
+// addChainFlags adds the flags setting the consensus rules, which every process
+// validating blocks must share.
+func addChainFlags(flags *flag.FlagSet) {
+	flags.DurationVar(&TargetBlockInterval, "blocktime", TargetBlockInterval, "target time between blocks, must match on every node")
+	flags.IntVar(&RetargetInterval, "retarget", RetargetInterval, "number of blocks between difficulty adjustments, must match on every node")
+}
+
+// checkChainFlags exits when the consensus rules the flags set are out of range.
+func checkChainFlags() {
+	if TargetBlockInterval <= 0 {
+		log.Fatalf("Invalid -blocktime %v, must be positive", TargetBlockInterval)
+	}
+	if RetargetInterval <= 0 {
+		log.Fatalf("Invalid -retarget %d, must be positive", RetargetInterval)
+	}
+}
+
+func startWalletApp(port string, args []string) {
+	flags := flag.NewFlagSet("wallet", flag.ExitOnError)
+	seeds := flags.String("seeds", defaultSeeds, "comma separated addresses of nodes asked for peers")
+	addChainFlags(flags)
+	transport := addTransportFlags(flags)
+	flags.Parse(args)
+	checkChainFlags()
+
 	// First delete the genesis block file
 	err := os.Remove(genesisBlockFile)
 	if err != nil && !os.IsNotExist(err) {
 		log.Fatalf("Failed to delete genesis block file: %v", err)
 	}
 
-	// First delete the nodes.txt file
-	err = os.Remove("nodes.txt")
-	if err != nil && !os.IsNotExist(err) {
-		log.Fatalf("Failed to delete nodes.txt file: %v", err)
-	}
-
 	// Delete the consensus blockchain file
 	err = os.Remove("consensus.blockchain")
 	if err != nil && !os.IsNotExist(err) {
@@ -25,38 +79,45 @@ func startWalletApp(port string) {
 	}
 
 	// Start the wallet application
-	app := NewApplication()
+	app := NewApplication(parseSeeds(*seeds), transport.transport(filepath.Join("data", "wallet-"+port, identityFileName)))
 	app.start(port)
 }
 
-func startBlockchainNode(port string) {
-	blockchain := NewBlockchain() // Initialize the blockchain, loading or creating the genesis block
+func startBlockchainNode(port string, args []string) {
+	flags := flag.NewFlagSet("node", flag.ExitOnError)
+	dataDir := flags.String("datadir", filepath.Join("data", port), "directory where the node persists its blockchain")
+	addChainFlags(flags)
+	minerAddress := flags.String("miner", "", "address paid the block reward of mined blocks")
+	flags.IntVar(&MiningWorkers, "workers", MiningWorkers, "number of goroutines searching for block nonces")
+	flags.Float64Var(&MinRelayFee, "minrelayfee", MinRelayFee, "minimum fee per 1000 bytes for transactions accepted into the mempool")
+	seeds := flags.String("seeds", defaultSeeds, "comma separated addresses of nodes asked for peers")
+	flags.IntVar(&MaxOutboundPeers, "maxpeers", MaxOutboundPeers, "maximum number of peers blocks are relayed to and synced from")
+	transport := addTransportFlags(flags)
+	rpcAddress := flags.String("rpcaddr", "", "address serving the JSON-RPC API and the event stream over HTTP, e.g. 127.0.0.1:8545; off when empty")
+	rpcPublic := flags.Bool("rpcpublic", false, "allow -rpcaddr to listen on an address other hosts can reach, the API has no authentication")
+	flags.Parse(args)
+	checkChainFlags()
+	if *rpcAddress != "" && !isLoopback(*rpcAddress) && !*rpcPublic {
+		log.Fatalf("Invalid -rpcaddr %s, must be a loopback address such as 127.0.0.1:8545 unless -rpcpublic is given", *rpcAddress)
+	}
+
+	blockchain := NewBlockchain(*dataDir) // Load the stored chain, or start from the genesis block
 
 	nodeAddress := "127.0.0.1:" + port
 	node := NewNode(nodeAddress, blockchain)
-
-	// Write the node address to nodes.txt
-	writeAddressToFile(nodeAddress, "nodes.txt")
+	node.MinerAddress = *minerAddress
+	node.Guard = NewPeerGuard(*dataDir) // Bans outlive a restart
+	node.Transport = transport.transport(filepath.Join(*dataDir, identityFileName))
+	node.JSONRPCAddress = *rpcAddress
+	node.UsePeers(NewPeerManager(nodeAddress, *dataDir, parseSeeds(*seeds))) // Reload the peers known before a restart
 
 	log.Printf("Node running at %s\n", nodeAddress)
 	node.Start()
 }
 
-func writeAddressToFile(address, filename string) {
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	if _, err := file.WriteString(address + "\n"); err != nil {
-		log.Fatal(err)
-	}
-}
-
 func main() {
 	if len(os.Args) < 3 {
-		log.Fatal("Usage: go run . [wallet|node|consensus|task] [num]")
+		log.Fatal("Usage: go run . [wallet|node|consensus|task] [num] [flags]")
 	}
 
 	mode := os.Args[1]
@@ -64,11 +125,18 @@ func main() {
 
 	switch mode {
 	case "wallet":
-		startWalletApp(num)
+		startWalletApp(num, os.Args[3:])
 	case "node":
-		startBlockchainNode(num)
+		startBlockchainNode(num, os.Args[3:])
 	case "consensus":
-		consensus := NewConsensus()
+		flags := flag.NewFlagSet("consensus", flag.ExitOnError)
+		seeds := flags.String("seeds", defaultSeeds, "comma separated addresses of nodes asked for peers")
+		addChainFlags(flags)
+		transport := addTransportFlags(flags)
+		flags.Parse(os.Args[3:])
+		checkChainFlags()
+
+		consensus := NewConsensus(parseSeeds(*seeds), transport.transport(filepath.Join("data", "consensus-"+num, identityFileName)))
 		consensus.Start()
 	case "task":
 		if num == "one" {
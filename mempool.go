@@ -1,26 +1,275 @@
 package main
 
-// Mempool represents a memory pool for transactions.
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	MinRelayFee            = 0.0       // Lowest fee per 1000 bytes a transaction must pay to enter the mempool
+	MaxMempoolTransactions = 5000      // Pending transactions kept before the lowest fee rates are evicted
+	MempoolExpiry          = time.Hour // How long a transaction may wait in the mempool
+)
+
+var (
+	ErrAlreadyInMempool = errors.New("transaction already in mempool")
+	ErrMempoolConflict  = errors.New("transaction spends an output already spent by a pending transaction")
+	ErrMempoolFull      = errors.New("mempool is full and the transaction's fee rate is too low")
+	ErrNonceGap         = errors.New("transaction skips a nonce of its sender")
+)
+
+// mempoolEntry is a pending transaction and when it arrived. The fee rate is computed
+// once, when the transaction is added, since it takes encoding the transaction.
+type mempoolEntry struct {
+	tx      *Transaction
+	added   time.Time
+	feeRate float64
+}
+
+func newMempoolEntry(tx *Transaction, added time.Time) *mempoolEntry {
+	return &mempoolEntry{tx: tx, added: added, feeRate: tx.FeeRate()}
+}
+
+// Mempool represents a memory pool for transactions. It is safe for concurrent use.
+//
+// Transactions are indexed by ID, so a transaction relayed by several nodes is only
+// kept once, and ordered by fee rate, so the cheapest ones are evicted when the pool
+// is full. Entries older than MempoolExpiry are dropped.
 type Mempool struct {
-	Transactions []*Transaction // A slice of pointers to transactions
+	mutex   sync.Mutex
+	entries map[string]*mempoolEntry // Pending transactions by hex-encoded ID
+	ordered []*mempoolEntry          // Pending transactions, highest fee rate first
 }
 
 // NewMempool creates and returns a new Mempool instance.
 func NewMempool() *Mempool {
-	return &Mempool{Transactions: []*Transaction{}}
+	return &Mempool{entries: make(map[string]*mempoolEntry)}
+}
+
+// AddTransaction adds a transaction to the Mempool, keeping it ordered by fee rate.
+// Transactions paying the same rate stay in arrival order. Duplicates and transactions
+// spending an output a pending one already spends are rejected. When the pool is full
+// the lowest fee rate is evicted, unless the new transaction pays no more than it.
+func (m *Mempool) AddTransaction(tx *Transaction) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.expire(time.Now())
+
+	if _, ok := m.entries[hex.EncodeToString(tx.ID)]; ok {
+		return ErrAlreadyInMempool
+	}
+	if m.hasConflict(tx) {
+		return ErrMempoolConflict
+	}
+	entry := newMempoolEntry(tx, time.Now())
+	for len(m.ordered) >= MaxMempoolTransactions {
+		lowest := m.ordered[len(m.ordered)-1]
+		if lowest.feeRate >= entry.feeRate || lowest.tx.From == tx.From {
+			return ErrMempoolFull
+		}
+		m.removeWithDescendants(lowest.tx)
+	}
+
+	m.entries[hex.EncodeToString(tx.ID)] = entry
+	index := sort.Search(len(m.ordered), func(i int) bool {
+		return m.ordered[i].feeRate < entry.feeRate
+	})
+	m.ordered = append(m.ordered, nil)
+	copy(m.ordered[index+1:], m.ordered[index:])
+	m.ordered[index] = entry
+	return nil
+}
+
+// RemoveTransactions drops the given transactions, typically because a block included them.
+func (m *Mempool) RemoveTransactions(txs []*Transaction) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, tx := range txs {
+		m.remove(tx)
+	}
+}
+
+// Expire drops transactions that have waited longer than MempoolExpiry and returns them.
+func (m *Mempool) Expire() []*Transaction {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.expire(time.Now())
+}
+
+// Contains reports whether a transaction with the given ID is pending.
+func (m *Mempool) Contains(txID []byte) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, ok := m.entries[hex.EncodeToString(txID)]
+	return ok
+}
+
+// Get returns the pending transaction with the given ID, or nil.
+func (m *Mempool) Get(txID []byte) *Transaction {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if entry, ok := m.entries[hex.EncodeToString(txID)]; ok {
+		return entry.tx
+	}
+	return nil
+}
+
+// Len returns the number of pending transactions.
+func (m *Mempool) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.ordered)
 }
 
-// AddTransaction adds a transaction to the Mempool.
-func (m *Mempool) AddTransaction(tx *Transaction) {
-	m.Transactions = append(m.Transactions, tx)
+// NextNonce returns the nonce expected after the pending transactions of address,
+// starting from the confirmed nonce of the chain.
+func (m *Mempool) NextNonce(address string, confirmed uint64) uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	next := confirmed
+	for _, pending := range m.pendingFrom(address) {
+		if pending.Nonce == next {
+			next++
+		}
+	}
+	return next
 }
 
-// GetTransactions returns all transactions in the Mempool.
+// PendingFrom returns the pending transactions sent by address in nonce order.
+func (m *Mempool) PendingFrom(address string) []*Transaction {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.pendingFrom(address)
+}
+
+// GetTransactions returns all transactions in the Mempool, highest fee rate first.
 func (m *Mempool) GetTransactions() []*Transaction {
-	return m.Transactions
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	txs := make([]*Transaction, len(m.ordered))
+	for i, entry := range m.ordered {
+		txs[i] = entry.tx
+	}
+	return txs
 }
 
 // Clear empties all transactions from the Mempool.
 func (m *Mempool) Clear() {
-	m.Transactions = []*Transaction{}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entries = make(map[string]*mempoolEntry)
+	m.ordered = nil
+}
+
+// arrivals returns when each pending transaction arrived, by hex-encoded ID.
+func (m *Mempool) arrivals() map[string]time.Time {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	arrivals := make(map[string]time.Time, len(m.entries))
+	for key, entry := range m.entries {
+		arrivals[key] = entry.added
+	}
+	return arrivals
+}
+
+// journalEntries returns the pending transactions with their arrival times, in fee rate order.
+func (m *Mempool) journalEntries() []journalEntry {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entries := make([]journalEntry, 0, len(m.ordered))
+	for _, entry := range m.ordered {
+		entries = append(entries, journalEntry{Tx: entry.tx, Added: entry.added})
+	}
+	return entries
+}
+
+// restoreArrivals puts back the arrival times of re-added transactions, so revalidating
+// the pool does not reset their expiry.
+func (m *Mempool) restoreArrivals(arrivals map[string]time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for key, entry := range m.entries {
+		if added, ok := arrivals[key]; ok {
+			entry.added = added
+		}
+	}
+}
+
+func (m *Mempool) hasConflict(tx *Transaction) bool {
+	for _, pending := range m.ordered {
+		for _, input := range pending.tx.Inputs {
+			for _, wanted := range tx.Inputs {
+				if outpoint(input.TxID, input.Vout) == outpoint(wanted.TxID, wanted.Vout) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (m *Mempool) pendingFrom(address string) []*Transaction {
+	var pending []*Transaction
+	for _, entry := range m.ordered {
+		if entry.tx.From == address {
+			pending = append(pending, entry.tx)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Nonce < pending[j].Nonce })
+	return pending
+}
+
+// expire drops stale entries together with the sender's later transactions, which could
+// no longer be mined without them.
+func (m *Mempool) expire(now time.Time) []*Transaction {
+	var expired []*Transaction
+	for _, entry := range m.entries {
+		if now.Sub(entry.added) > MempoolExpiry {
+			expired = append(expired, m.removeWithDescendants(entry.tx)...)
+		}
+	}
+	return expired
+}
+
+// removeWithDescendants removes tx and the pending transactions of its sender with higher nonces.
+func (m *Mempool) removeWithDescendants(tx *Transaction) []*Transaction {
+	var removed []*Transaction
+	for _, pending := range m.pendingFrom(tx.From) {
+		if pending == tx || pending.Nonce > tx.Nonce {
+			m.remove(pending)
+			removed = append(removed, pending)
+		}
+	}
+	return removed
+}
+
+func (m *Mempool) remove(tx *Transaction) {
+	key := hex.EncodeToString(tx.ID)
+	if _, ok := m.entries[key]; !ok {
+		return
+	}
+	delete(m.entries, key)
+	for i, pending := range m.ordered {
+		if bytes.Equal(pending.tx.ID, tx.ID) {
+			m.ordered = append(m.ordered[:i], m.ordered[i+1:]...)
+			break
+		}
+	}
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestMempoolOperations(t *testing.T) {
@@ -13,17 +14,114 @@ func TestMempoolOperations(t *testing.T) {
 	mempool.AddTransaction(tx1)
 	mempool.AddTransaction(tx2)
 
-	if len(mempool.Transactions) != 2 {
-		t.Errorf("AddTransaction() failed, expected 2 transactions in mempool, got %v", len(mempool.Transactions))
+	if mempool.Len() != 2 {
+		t.Errorf("AddTransaction() failed, expected 2 transactions in mempool, got %v", mempool.Len())
 	}
 
 	transactions := mempool.GetTransactions()
-	if len(transactions) != 2 || !reflect.DeepEqual(transactions, mempool.Transactions) {
+	if len(transactions) != 2 || !reflect.DeepEqual(transactions, []*Transaction{tx1, tx2}) {
 		t.Error("GetTransactions() failed, the transactions returned are not correct")
 	}
 
 	mempool.Clear()
-	if len(mempool.Transactions) != 0 {
+	if mempool.Len() != 0 {
 		t.Error("Clear() failed, the mempool should be empty")
 	}
 }
+
+func TestMempoolOrdersByFeeRate(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+	carol := NewWallet()
+
+	blockchain := newTestChain(t, alice.Address(), bob.Address())
+
+	cheap, _ := NewSignedTransactionWithFee(alice, carol.Address(), 10, 1, 0)
+	urgent, _ := NewSignedTransactionWithFee(alice, carol.Address(), 10, 20, 1)
+	medium, _ := NewSignedTransactionWithFee(bob, carol.Address(), 10, 5, 0)
+	for _, tx := range []*Transaction{cheap, urgent, medium} {
+		if err := blockchain.AddTransactionToMempool(tx); err != nil {
+			t.Fatalf("AddTransactionToMempool() failed with error: %v", err)
+		}
+	}
+	if blockchain.Mempool.GetTransactions()[0] != urgent {
+		t.Error("AddTransaction() failed, the highest fee rate should come first")
+	}
+
+	// urgent pays the most but has to wait for alice's cheaper transaction with nonce 0
+	selected := blockchain.NewBlockTemplate("").Transactions
+	if len(selected) != 3 || selected[0] != medium || selected[1] != cheap || selected[2] != urgent {
+		t.Error("NewBlockTemplate() failed, expected fee rate order that respects nonces")
+	}
+
+	blockchain.Mempool.RemoveTransactions(selected[:1])
+	if blockchain.Mempool.Len() != 2 {
+		t.Errorf("RemoveTransactions() failed, expected 2 transactions left, got %d", blockchain.Mempool.Len())
+	}
+
+	defer func(fee float64) { MinRelayFee = fee }(MinRelayFee)
+	MinRelayFee = 1000
+	lowFee, _ := NewSignedTransactionWithFee(bob, carol.Address(), 10, 1, 1)
+	if err := blockchain.AddTransactionToMempool(lowFee); err == nil {
+		t.Error("AddTransactionToMempool() failed, a fee below the minimum relay fee should be rejected")
+	}
+}
+
+func TestMempoolRejectsDuplicatesAndCombinedOverspend(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+
+	blockchain := newTestChain(t, alice.Address())
+
+	tx, _ := NewSignedTransaction(alice, bob.Address(), 60, 0)
+	if err := blockchain.AddTransactionToMempool(tx); err != nil {
+		t.Fatalf("AddTransactionToMempool() failed with error: %v", err)
+	}
+	if err := blockchain.AddTransactionToMempool(tx); err != ErrAlreadyInMempool {
+		t.Errorf("AddTransactionToMempool() failed, expected ErrAlreadyInMempool, got %v", err)
+	}
+
+	// Each transaction is covered by the balance on its own, but not together with the pending one
+	overspend, _ := NewSignedTransaction(alice, bob.Address(), 50, 1)
+	if err := blockchain.AddTransactionToMempool(overspend); err == nil {
+		t.Error("AddTransactionToMempool() failed, spending more than the balance with pending transactions should be rejected")
+	}
+	if blockchain.Mempool.Len() != 1 {
+		t.Errorf("AddTransactionToMempool() failed, expected 1 pending transaction, got %d", blockchain.Mempool.Len())
+	}
+}
+
+func TestMempoolEvictionAndExpiry(t *testing.T) {
+	defer func(size int, expiry time.Duration) {
+		MaxMempoolTransactions, MempoolExpiry = size, expiry
+	}(MaxMempoolTransactions, MempoolExpiry)
+	MaxMempoolTransactions = 2
+
+	mempool := NewMempool()
+	low := NewTransaction("from1", "to", 10)
+	low.Fee = 1
+	high := NewTransaction("from2", "to", 10)
+	high.Fee = 10
+	mempool.AddTransaction(low)
+	mempool.AddTransaction(high)
+
+	lower := NewTransaction("from3", "to", 10)
+	if err := mempool.AddTransaction(lower); err != ErrMempoolFull {
+		t.Errorf("AddTransaction() failed, expected ErrMempoolFull, got %v", err)
+	}
+
+	higher := NewTransaction("from3", "to", 10)
+	higher.Fee = 5
+	if err := mempool.AddTransaction(higher); err != nil {
+		t.Fatalf("AddTransaction() failed with error: %v", err)
+	}
+	if mempool.Contains(low.ID) || !mempool.Contains(higher.ID) {
+		t.Error("AddTransaction() failed, the lowest fee rate should have been evicted")
+	}
+
+	MempoolExpiry = 0
+	time.Sleep(time.Millisecond)
+	if expired := mempool.Expire(); len(expired) != 2 || mempool.Len() != 0 {
+		t.Errorf("Expire() failed, expected 2 expired transactions, got %d", len(expired))
+	}
+}
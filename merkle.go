@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// Leaves and inner nodes are hashed with different prefixes so an inner node
+// can never be passed off as a transaction ID.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// MerkleProofStep is one sibling hash on the path from a transaction to the Merkle root.
+type MerkleProofStep struct {
+	Hash []byte // Hash of the sibling node
+	Left bool   // Whether the sibling sits to the left of the path
+}
+
+// MerkleProof proves that a transaction is included in a block.
+type MerkleProof struct {
+	TxID       []byte            // Transaction being proven
+	BlockHash  []byte            // Block that contains it
+	Height     int               // Height of that block
+	MerkleRoot []byte            // Merkle root recorded in the block header
+	Steps      []MerkleProofStep // Siblings from the leaf up to the root
+}
+
+func merkleLeaf(txID []byte) []byte {
+	hash := sha256.Sum256(append([]byte{merkleLeafPrefix}, txID...))
+	return hash[:]
+}
+
+func merkleNode(left, right []byte) []byte {
+	data := append([]byte{merkleNodePrefix}, left...)
+	hash := sha256.Sum256(append(data, right...))
+	return hash[:]
+}
+
+// merkleLevels builds every level of the tree, leaves first. A node without a
+// sibling is carried up to the next level unchanged.
+func merkleLevels(txIDs [][]byte) [][][]byte {
+	level := make([][]byte, len(txIDs))
+	for i, txID := range txIDs {
+		level[i] = merkleLeaf(txID)
+	}
+
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, merkleNode(level[i], level[i+1]))
+			}
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// ComputeMerkleRoot returns the Merkle root over a list of transaction IDs.
+func ComputeMerkleRoot(txIDs [][]byte) []byte {
+	if len(txIDs) == 0 {
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	}
+	levels := merkleLevels(txIDs)
+	return levels[len(levels)-1][0]
+}
+
+// merklePath returns the sibling hashes needed to rebuild the root from the leaf at index.
+func merklePath(txIDs [][]byte, index int) []MerkleProofStep {
+	var steps []MerkleProofStep
+	levels := merkleLevels(txIDs)
+	for _, level := range levels[:len(levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			steps = append(steps, MerkleProofStep{Hash: level[sibling], Left: sibling < index})
+		}
+		index /= 2
+	}
+	return steps
+}
+
+// VerifyMerkleProof checks that the proof's steps lead from the transaction ID to its Merkle root.
+func VerifyMerkleProof(proof *MerkleProof) bool {
+	hash := merkleLeaf(proof.TxID)
+	for _, step := range proof.Steps {
+		if step.Left {
+			hash = merkleNode(step.Hash, hash)
+		} else {
+			hash = merkleNode(hash, step.Hash)
+		}
+	}
+	return bytes.Equal(hash, proof.MerkleRoot)
+}
+
+// HashTransactions returns the Merkle root over the IDs of the block's transactions.
+func (b *Block) HashTransactions() []byte {
+	return ComputeMerkleRoot(b.transactionIDs())
+}
+
+func (b *Block) transactionIDs() [][]byte {
+	txIDs := make([][]byte, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		txIDs[i] = tx.ID
+	}
+	return txIDs
+}
+
+// FindMerkleProof builds an inclusion proof for a transaction on the chain.
+func (bc *Blockchain) FindMerkleProof(txID []byte) (*MerkleProof, error) {
+	for height, block := range bc.Blocks {
+		for index, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, txID) {
+				return &MerkleProof{
+					TxID:       tx.ID,
+					BlockHash:  block.Hash,
+					Height:     height,
+					MerkleRoot: block.MerkleRoot,
+					Steps:      merklePath(block.transactionIDs(), index),
+				}, nil
+			}
+		}
+	}
+	return nil, ErrTransactionNotFound
+}
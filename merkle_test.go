@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleProofsVerify(t *testing.T) {
+	for _, count := range []int{1, 2, 3, 5, 8} {
+		var txIDs [][]byte
+		for i := 0; i < count; i++ {
+			txIDs = append(txIDs, []byte(fmt.Sprintf("tx-%d", i)))
+		}
+		root := ComputeMerkleRoot(txIDs)
+
+		for index, txID := range txIDs {
+			proof := &MerkleProof{TxID: txID, MerkleRoot: root, Steps: merklePath(txIDs, index)}
+			if !VerifyMerkleProof(proof) {
+				t.Errorf("VerifyMerkleProof() failed for transaction %d of %d", index, count)
+			}
+
+			proof.TxID = []byte("forged")
+			if VerifyMerkleProof(proof) {
+				t.Errorf("VerifyMerkleProof() failed, accepted a forged transaction %d of %d", index, count)
+			}
+		}
+	}
+}
+
+func TestFindMerkleProof(t *testing.T) {
+	blockchain := NewBlockchain("")
+	txs := []*Transaction{
+		NewTransaction("from", "to", 1),
+		NewTransaction("from", "to", 2),
+		NewTransaction("from", "to", 3),
+	}
+	block := NewBlock(txs, blockchain.Blocks[len(blockchain.Blocks)-1].Hash)
+	blockchain.Blocks = append(blockchain.Blocks, block)
+
+	proof, err := blockchain.FindMerkleProof(txs[2].ID)
+	if err != nil {
+		t.Fatalf("FindMerkleProof() failed with error: %v", err)
+	}
+	if proof.Height != 1 || !bytes.Equal(proof.BlockHash, block.Hash) || !bytes.Equal(proof.MerkleRoot, block.MerkleRoot) {
+		t.Error("FindMerkleProof() failed, the proof does not point to the containing block")
+	}
+	if !VerifyMerkleProof(proof) {
+		t.Error("FindMerkleProof() failed, the proof does not verify")
+	}
+
+	if _, err := blockchain.FindMerkleProof([]byte("missing")); err != ErrTransactionNotFound {
+		t.Errorf("FindMerkleProof() failed, expected ErrTransactionNotFound, got %v", err)
+	}
+}
+
+func TestValidateRejectsWrongMerkleRoot(t *testing.T) {
+	blockchain := NewBlockchain("")
+	parent := blockchain.Blocks[len(blockchain.Blocks)-1]
+	block := NewBlock([]*Transaction{NewTransaction("from", "to", 1)}, parent.Hash)
+
+	block.Transactions = append(block.Transactions, NewTransaction("from", "to", 2))
+	err := AsBlockValidationError(block.Validate(parent))
+	if err == nil || err.Rule != RuleMerkleRoot {
+		t.Errorf("Validate() failed, expected rule %q, got %v", RuleMerkleRoot, err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var MiningWorkers = runtime.NumCPU() // Goroutines searching the nonce space in parallel
+
+var maxNonce = 1<<31 - 1 // Highest nonce tried before the timestamp is rolled forward
+
+const cancelCheckInterval = 1 << 12 // Hashes a worker computes between checks for cancellation
+
+// MiningStats describes the work done to mine a block.
+type MiningStats struct {
+	Hashes   uint64
+	Duration time.Duration
+}
+
+// Hashrate returns the hashes computed per second.
+func (s MiningStats) Hashrate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Hashes) / s.Duration.Seconds()
+}
+
+// Mine searches for a nonce that gives the block a hash below its target.
+//
+// The nonce space is split between workers goroutines, each trying every workers-th
+// nonce. When every nonce fails for the current timestamp, the timestamp is rolled
+// forward and the search starts over. Mining stops with ctx's error when ctx is cancelled,
+// for example because a competing block has extended the chain first.
+func (b *Block) Mine(ctx context.Context, workers int) (MiningStats, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	if b.Difficulty == 0 {
+		b.Difficulty = targetBits
+	}
+	b.MerkleRoot = b.HashTransactions()
+	target := b.target()
+
+	start := time.Now()
+	var hashes uint64
+	for {
+		nonce, found := b.searchNonces(ctx, target, workers, &hashes)
+		stats := MiningStats{Hashes: atomic.LoadUint64(&hashes), Duration: time.Since(start)}
+		if found {
+			b.Nonce = nonce
+			b.SetHash()
+			return stats, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		// Every nonce failed for this timestamp, so roll it forward for a fresh search space
+		b.Timestamp++
+		if now := time.Now().Unix(); now > b.Timestamp {
+			b.Timestamp = now
+		}
+	}
+}
+
+// searchNonces runs the workers over the whole nonce range for the current header and
+// returns the first nonce found, if any.
+func (b *Block) searchNonces(ctx context.Context, target *big.Int, workers int, hashes *uint64) (int, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan int, workers)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(first int) {
+			defer wg.Done()
+
+			var hashInt big.Int
+			var count uint64
+			defer func() { atomic.AddUint64(hashes, count) }()
+
+			for nonce := first; nonce < maxNonce; nonce += workers {
+				if count%cancelCheckInterval == 0 && ctx.Err() != nil {
+					return
+				}
+				hash := sha256.Sum256(prepareData(b, nonce))
+				count++
+				hashInt.SetBytes(hash[:])
+				if hashInt.Cmp(target) < 0 {
+					found <- nonce
+					cancel() // Stop the other workers
+					return
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	select {
+	case nonce := <-found:
+		return nonce, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMineWithWorkers(t *testing.T) {
+	parent := NewBlock([]*Transaction{}, []byte{})
+	block := &Block{Timestamp: parent.Timestamp, Transactions: []*Transaction{}, PrevBlockHash: parent.Hash, Difficulty: 8}
+
+	stats, err := block.Mine(context.Background(), 4)
+	if err != nil {
+		t.Fatalf("Mine() failed with error: %v", err)
+	}
+	if err := block.Validate(parent); err != nil {
+		t.Errorf("Mine() failed, the mined block is invalid: %v", err)
+	}
+	if stats.Hashes == 0 {
+		t.Error("Mine() failed, expected the hashes to be counted")
+	}
+}
+
+func TestMineStopsWhenCancelled(t *testing.T) {
+	block := &Block{Timestamp: time.Now().Unix(), Transactions: []*Transaction{}, Difficulty: maxDifficulty}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := block.Mine(ctx, 2); err != context.DeadlineExceeded {
+		t.Errorf("Mine() failed, expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestMineRollsTimestamp(t *testing.T) {
+	defer func(limit int) { maxNonce = limit }(maxNonce)
+	maxNonce = 1 // Only nonce 0 is tried for each timestamp
+
+	parent := NewBlock([]*Transaction{}, []byte{})
+	block := &Block{Timestamp: parent.Timestamp, Transactions: []*Transaction{}, PrevBlockHash: parent.Hash, Difficulty: targetBits}
+	if _, err := block.Mine(context.Background(), 2); err != nil {
+		t.Fatalf("Mine() failed with error: %v", err)
+	}
+	if block.Nonce != 0 || block.Validate(parent) != nil {
+		t.Error("Mine() failed, expected a valid block found by rolling the timestamp")
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	bansFileName        = "bans.json" // Active bans persisted in a node's data directory
+	bucketPruneInterval = time.Minute // Interval between sweeps of the idle token buckets
+)
+
+// Misbehavior scores. A peer reaching BanThreshold is banned for BanDuration.
+const (
+	scoreInvalidBlock         = 50  // Block failing validation
+	scoreBadProofOfWork       = 100 // Block whose hash does not match its header or its difficulty
+	scoreMalformedTransaction = 20  // Transaction with a bad signature or negative amounts
+	scoreFlooding             = 5   // Request dropped by a rate limit
+)
+
+var (
+	BanThreshold = 100            // Misbehavior score at which a peer is banned
+	BanDuration  = 24 * time.Hour // How long a ban lasts
+)
+
+var (
+	ErrMalformedTransaction = errors.New("malformed transaction")
+	ErrRateLimited          = errors.New("rate limit exceeded")
+	ErrPeerBanned           = errors.New("peer is banned")
+	ErrNotPermitted         = errors.New("method only available to local callers")
+)
+
+// rateLimit is the steady rate, in requests per second, and the burst a token bucket allows.
+type rateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+var (
+	peerRateLimit = rateLimit{Rate: 100, Burst: 200} // All requests of one peer
+
+	// Requests of one peer to one method; methods not listed only count against peerRateLimit
+	methodRateLimits = map[string]rateLimit{
+		"Node.ReceiveNewBlock":      {Rate: 1, Burst: 10},
+		"Node.ReceiveTransaction":   {Rate: 10, Burst: 50},
+		"Node.GetCurrentBlockchain": {Rate: 0.5, Burst: 5},
+		"Node.GetHeaders":           {Rate: 5, Burst: 20},
+		"Node.GetBlocks":            {Rate: 50, Burst: 200},
+		"Node.AddrAnnounce":         {Rate: 1, Burst: 10},
+		"Node.GetPeers":             {Rate: 1, Burst: 10},
+		"jsonrpc.sendTransaction":   {Rate: 10, Burst: 50},
+	}
+
+	// Methods only served to callers on the loopback interface
+	adminMethods = map[string]bool{
+		"Node.ListBans": true,
+		"Node.ClearBan": true,
+	}
+)
+
+// tokenBucket allows Burst requests at once and refills at Rate tokens per second.
+type tokenBucket struct {
+	limit  rateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit rateLimit) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: limit.Burst, last: time.Now()}
+}
+
+// full reports whether the bucket has refilled to its burst by now, so that dropping it
+// changes nothing.
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= b.limit.Burst
+}
+
+// allow takes a token if one is left.
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if b.tokens > b.limit.Burst {
+		b.tokens = b.limit.Burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Ban is a peer refused until a given time.
+type Ban struct {
+	Peer   string
+	Until  time.Time
+	Reason string
+}
+
+// PeerGuard scores peer misbehavior, bans the peers that misbehave too much and rate
+// limits the requests of each peer. Peers are identified by their TLS identity or, over
+// plain TCP, by their full address, so processes sharing a host, as in a local demo, are
+// scored apart. Bans are saved in the node's data directory and survive a
+// restart; scores and buckets do not.
+type PeerGuard struct {
+	mutex     sync.Mutex
+	path      string // Where bans are saved, empty to keep them in memory
+	scores    map[string]int
+	bans      map[string]Ban
+	buckets   map[string]*tokenBucket // By peer, and by peer and method
+	lastPrune time.Time
+}
+
+// NewPeerGuard creates a guard, loading the bans saved in dataDir if there are any.
+func NewPeerGuard(dataDir string) *PeerGuard {
+	g := &PeerGuard{
+		scores:  make(map[string]int),
+		bans:    make(map[string]Ban),
+		buckets: make(map[string]*tokenBucket),
+	}
+	if dataDir != "" {
+		g.path = filepath.Join(dataDir, bansFileName)
+		if err := g.load(); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error loading bans: %v", err)
+		}
+	}
+	return g
+}
+
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Misbehaving adds score to a peer and bans it once it reaches BanThreshold. It reports
+// whether the peer is banned.
+func (g *PeerGuard) Misbehaving(peer string, score int, reason string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.scores[peer] += score
+	log.Printf("Peer %s misbehaved (%s), score %d", peer, reason, g.scores[peer])
+	if g.scores[peer] < BanThreshold {
+		return false
+	}
+
+	g.bans[peer] = Ban{Peer: peer, Until: time.Now().Add(BanDuration), Reason: reason}
+	delete(g.scores, peer)
+	log.Printf("Banning peer %s until %s: %s", peer, g.bans[peer].Until.Format(time.RFC3339), reason)
+	if err := g.save(); err != nil {
+		log.Printf("Error saving bans: %v", err)
+	}
+	return true
+}
+
+// IsBanned reports whether a peer is banned. Expired bans are lifted.
+func (g *PeerGuard) IsBanned(peer string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	ban, ok := g.bans[peer]
+	if ok && time.Now().After(ban.Until) {
+		delete(g.bans, peer)
+		return false
+	}
+	return ok
+}
+
+// Bans returns the active bans, ending soonest first.
+func (g *PeerGuard) Bans() []Ban {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	var bans []Ban
+	for _, ban := range g.bans {
+		if now.Before(ban.Until) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+	return bans
+}
+
+// ClearBan lifts the ban of a peer, or every ban when peer is empty, and reports how many
+// bans were lifted.
+func (g *PeerGuard) ClearBan(peer string) (int, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	cleared := 0
+	for key := range g.bans {
+		if peer == "" || key == peer {
+			delete(g.bans, key)
+			delete(g.scores, key)
+			cleared++
+		}
+	}
+	return cleared, g.save()
+}
+
+// allow charges a request to the peer's buckets and reports whether it may proceed.
+func (g *PeerGuard) allow(peer, method string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(g.lastPrune) > bucketPruneInterval {
+		g.pruneBuckets(now)
+	}
+	bucket := g.buckets[peer]
+	if bucket == nil {
+		bucket = newTokenBucket(peerRateLimit)
+		g.buckets[peer] = bucket
+	}
+	if !bucket.allow(now) {
+		return false
+	}
+
+	limit, ok := methodRateLimits[method]
+	if !ok {
+		return true
+	}
+	key := peer + " " + method
+	bucket = g.buckets[key]
+	if bucket == nil {
+		bucket = newTokenBucket(limit)
+		g.buckets[key] = bucket
+	}
+	return bucket.allow(now)
+}
+
+// pruneBuckets drops the buckets that refilled since their last request, which a new
+// bucket would replace identically. Must be called with the mutex held.
+func (g *PeerGuard) pruneBuckets(now time.Time) {
+	for key, bucket := range g.buckets {
+		if bucket.full(now) {
+			delete(g.buckets, key)
+		}
+	}
+	g.lastPrune = now
+}
+
+// save must be called with the mutex held.
+func (g *PeerGuard) save() error {
+	if g.path == "" {
+		return nil
+	}
+	var bans []Ban
+	for _, ban := range g.bans {
+		bans = append(bans, ban)
+	}
+	data, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(g.path), 0755); err != nil {
+		return err
+	}
+	tmpPath := g.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, g.path)
+}
+
+// load reads the bans saved in the data directory, skipping the expired ones.
+func (g *PeerGuard) load() error {
+	data, err := os.ReadFile(g.path)
+	if err != nil {
+		return err
+	}
+	var bans []Ban
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, ban := range bans {
+		if now.Before(ban.Until) {
+			g.bans[ban.Peer] = ban
+		}
+	}
+	return nil
+}
+
+// misbehaviorScore returns the score a failed call charges to its caller, 0 when the
+// failure is no sign of misbehavior.
+func misbehaviorScore(err error) (int, string) {
+	if validationErr := AsBlockValidationError(err); validationErr != nil {
+		switch validationErr.Rule {
+		case RuleProofOfWork, RuleHash:
+			return scoreBadProofOfWork, validationErr.Error()
+		default:
+			return scoreInvalidBlock, validationErr.Error()
+		}
+	}
+	if err.Error() == ErrMalformedTransaction.Error() {
+		return scoreMalformedTransaction, err.Error()
+	}
+	return 0, ""
+}
+
+// guardedCodec serves the RPCs of one inbound connection through a PeerGuard: requests
+// from banned peers close the connection, except the admin requests of local callers,
+// so a banned host can still lift its ban; requests over a rate limit and admin requests
+// from remote callers are answered with an error without reaching the node, and failed
+// calls charge the caller's misbehavior score. The wire format is the gob encoding
+// of net/rpc.
+type guardedCodec struct {
+	guard  *PeerGuard
+	peer   string
+	local  bool // Caller on the loopback interface, allowed the admin methods
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	mutex  sync.Mutex // Serializes responses
+}
+
+// newGuardedCodec creates the codec of conn. The caller is known by id if it presented
+// one, otherwise by its address.
+func newGuardedCodec(guard *PeerGuard, conn net.Conn, id string) *guardedCodec {
+	buf := bufio.NewWriter(conn)
+	remote := conn.RemoteAddr().String()
+	peer := id
+	if peer == "" {
+		peer = remote
+	}
+	return &guardedCodec{
+		guard:  guard,
+		peer:   peer,
+		local:  isLoopback(remote),
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+	}
+}
+
+func (c *guardedCodec) ReadRequestHeader(r *rpc.Request) error {
+	for {
+		if err := c.dec.Decode(r); err != nil {
+			return err
+		}
+		if c.guard.IsBanned(c.peer) && !(c.local && adminMethods[r.ServiceMethod]) {
+			return ErrPeerBanned
+		}
+
+		var refusal error
+		switch {
+		case adminMethods[r.ServiceMethod] && !c.local:
+			refusal = ErrNotPermitted
+		case !c.guard.allow(c.peer, r.ServiceMethod):
+			refusal = ErrRateLimited
+			c.guard.Misbehaving(c.peer, scoreFlooding, "flooding "+r.ServiceMethod)
+		}
+		if refusal == nil {
+			return nil
+		}
+
+		// Skip the arguments and answer without calling the method
+		if err := c.ReadRequestBody(nil); err != nil { // A nil body is decoded and dropped
+			return err
+		}
+		response := &rpc.Response{ServiceMethod: r.ServiceMethod, Seq: r.Seq, Error: refusal.Error()}
+		if err := c.write(response, struct{}{}); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *guardedCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *guardedCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	if err := c.write(r, body); err != nil {
+		return err
+	}
+	if r.Error == "" {
+		return nil
+	}
+	if score, reason := misbehaviorScore(rpc.ServerError(r.Error)); score > 0 {
+		if c.guard.Misbehaving(c.peer, score, reason) {
+			c.rwc.Close()
+		}
+	}
+	return nil
+}
+
+func (c *guardedCodec) write(r *rpc.Response, body interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.enc.Encode(r); err != nil {
+		c.rwc.Close()
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		c.rwc.Close()
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *guardedCodec) Close() error {
+	return c.rwc.Close()
+}
+
+// ListBans returns the active bans. Only served to local callers.
+func (node *Node) ListBans(request string, reply *[]Ban) error {
+	*reply = node.Guard.Bans()
+	return nil
+}
+
+// ClearBan lifts the ban of a peer, or every ban when the request is empty. Only served
+// to local callers.
+func (node *Node) ClearBan(peer string, reply *string) error {
+	cleared, err := node.Guard.ClearBan(peer)
+	if err != nil {
+		return err
+	}
+	*reply = fmt.Sprintf("Cleared %d bans", cleared)
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(rateLimit{Rate: 1, Burst: 2})
+	now := bucket.last
+	if !bucket.allow(now) || !bucket.allow(now) {
+		t.Fatal("allow() failed, expected the burst to pass")
+	}
+	if bucket.allow(now) {
+		t.Error("allow() failed, expected a request over the burst to be refused")
+	}
+	if !bucket.allow(now.Add(time.Second)) {
+		t.Error("allow() failed, expected the bucket to refill")
+	}
+}
+
+func TestPeerGuardPersistsBans(t *testing.T) {
+	dataDir := t.TempDir()
+	guard := NewPeerGuard(dataDir)
+	if guard.Misbehaving("10.0.0.1", scoreInvalidBlock, "invalid block") {
+		t.Fatal("Misbehaving() failed, banned below the threshold")
+	}
+	if !guard.Misbehaving("10.0.0.1", scoreInvalidBlock, "invalid block") {
+		t.Fatal("Misbehaving() failed, expected a ban at the threshold")
+	}
+
+	restarted := NewPeerGuard(dataDir)
+	if !restarted.IsBanned("10.0.0.1") || restarted.IsBanned("10.0.0.2") {
+		t.Errorf("NewPeerGuard() failed, expected the saved ban only, got %v", restarted.Bans())
+	}
+	if cleared, err := restarted.ClearBan(""); err != nil || cleared != 1 {
+		t.Fatalf("ClearBan() failed, cleared %d bans with error %v", cleared, err)
+	}
+	if NewPeerGuard(dataDir).IsBanned("10.0.0.1") {
+		t.Error("ClearBan() failed, ban still saved")
+	}
+}
+
+func TestGuardedNodeRateLimits(t *testing.T) {
+	node := startTestNode(t, newTestChain(t))
+	client, err := rpc.Dial("tcp", node.Address)
+	if err != nil {
+		t.Fatalf("Dial() failed with error: %v", err)
+	}
+	defer client.Close()
+
+	limit := int(methodRateLimits["Node.GetCurrentBlockchain"].Burst)
+	for i := 0; i < limit; i++ {
+		var blocks []*Block
+		if err := client.Call("Node.GetCurrentBlockchain", "test", &blocks); err != nil {
+			t.Fatalf("Call() failed with error: %v", err)
+		}
+	}
+	var blocks []*Block
+	err = client.Call("Node.GetCurrentBlockchain", "test", &blocks)
+	if err == nil || err.Error() != ErrRateLimited.Error() {
+		t.Errorf("Call() failed, expected the rate limit, got %v", err)
+	}
+	// The connection stays usable for other methods
+	var peers []string
+	if err := client.Call("Node.GetPeers", "test", &peers); err != nil {
+		t.Errorf("Call() failed with error: %v", err)
+	}
+}
+
+func TestPeerGuardPrunesIdleBuckets(t *testing.T) {
+	guard := NewPeerGuard("")
+	if !guard.allow("10.0.0.1", "Node.GetPeers") || len(guard.buckets) != 2 {
+		t.Fatalf("allow() failed, expected a peer and a method bucket, got %d", len(guard.buckets))
+	}
+	guard.pruneBuckets(time.Now().Add(time.Hour))
+	if len(guard.buckets) != 0 {
+		t.Errorf("pruneBuckets() failed, %d refilled buckets kept", len(guard.buckets))
+	}
+}
+
+func TestGuardedNodeBansBadProofOfWork(t *testing.T) {
+	chain := newTestChain(t)
+	node := startTestNode(t, chain)
+	client, err := rpc.Dial("tcp", node.Address)
+	if err != nil {
+		t.Fatalf("Dial() failed with error: %v", err)
+	}
+	defer client.Close()
+
+	forged := *chain.Blocks[0]
+	forged.PrevBlockHash = []byte("unknown parent")
+	forged.Nonce++
+	var reply string
+	if err := client.Call("Node.ReceiveNewBlock", &forged, &reply); err == nil {
+		t.Fatal("Call() failed, expected the forged block to be rejected")
+	}
+	if err := client.Call("Node.ReceiveNewBlock", &forged, &reply); err == nil || strings.Contains(err.Error(), "invalid block") {
+		t.Errorf("Call() failed, expected the banned peer to be disconnected, got %v", err)
+	}
+
+	// Only the banned address is refused, not the other processes of the host
+	admin, err := rpc.Dial("tcp", node.Address)
+	if err != nil {
+		t.Fatalf("Dial() failed with error: %v", err)
+	}
+	defer admin.Close()
+	var peers []string
+	if err := admin.Call("Node.GetPeers", "test", &peers); err != nil {
+		t.Errorf("Call() failed, expected another address of the host to be served, got %v", err)
+	}
+	var bans []Ban
+	if err := admin.Call("Node.ListBans", "", &bans); err != nil || len(bans) != 1 || bans[0].Peer == "127.0.0.1" {
+		t.Fatalf("ListBans() failed, expected the ban of one address, got %v with error %v", bans, err)
+	}
+	if err := admin.Call("Node.ClearBan", bans[0].Peer, &reply); err != nil || node.Guard.IsBanned(bans[0].Peer) {
+		t.Errorf("ClearBan() failed with error: %v", err)
+	}
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"log"
 	"net"
 	"net/rpc"
+	"sort"
 	"sync"
 	"time"
 )
@@ -14,27 +17,416 @@ type Node struct {
 	Address         string
 	Blockchain      *Blockchain
 	BlockchainMutex sync.Mutex
+	MinerAddress    string       // Receives the coinbase of mined blocks, no coinbase when empty
+	Peers           *PeerManager // Nodes blocks are relayed to and synced from
+	Conns           *ConnManager // Open connections to the peers
+	Guard           *PeerGuard   // Misbehavior scores, bans and rate limits of the peers
+	Transport       *Transport   // Listens for and dials peers; nil for plain TCP
+	JSONRPCAddress  string       // Where the JSON-RPC API and the event stream are served, not served when empty
+	Events          *EventBus    // Chain and mempool events, streamed to subscribers
+
+	miningMutex  sync.Mutex
+	cancelMining context.CancelFunc // Aborts the block being mined, nil when idle
+
+	gossip     *Gossip
+	syncMutex  sync.Mutex // Held while syncing with the network
+	eventChain []*Block   // Chain as of the last published events
 }
 
 // NewNode creates a new Node instance
 func NewNode(address string, blockchain *Blockchain) *Node {
-	return &Node{
+	node := &Node{
 		Address:    address,
 		Blockchain: blockchain,
+		Guard:      NewPeerGuard(""),
+		Events:     NewEventBus(),
+		gossip:     NewGossip(),
+		eventChain: append([]*Block(nil), blockchain.Blocks...),
+	}
+	node.UsePeers(NewPeerManager(address, "", nil))
+	return node
+}
+
+// UsePeers makes the node relay to and sync from the peers of pm, which then describes
+// the node's chain in its handshakes.
+func (node *Node) UsePeers(pm *PeerManager) {
+	pm.LocalHandshake = node.localHandshake
+	pm.Transport = node.Transport
+	node.Peers = pm
+	node.Conns = NewConnManager(pm)
+	node.Conns.Guard = node.Guard
+}
+
+const (
+	dialTimeout           = 5 * time.Second  // Time allowed to open a connection to a peer
+	callTimeout           = 30 * time.Second // Time allowed for a peer to answer a call
+	minReconnectDelay     = 1 * time.Second  // Wait after the first failure before dialing a peer again
+	maxReconnectDelay     = 5 * time.Minute  // Longest wait between two dials of a failing peer
+	peerDiscoveryInterval = 30 * time.Second // Interval between rounds of peer discovery
+)
+
+var (
+	ErrPeerBackoff = errors.New("peer is unreachable, waiting before reconnecting")
+	ErrCallTimeout = errors.New("peer did not answer in time")
+)
+
+// ConnState is the state of the connection to a peer.
+type ConnState int
+
+const (
+	ConnDisconnected ConnState = iota // Not connected yet, or closed
+	ConnConnecting                    // Being dialed
+	ConnConnected                     // Connected and handshaken
+	ConnBackoff                       // The last attempt failed, waiting before dialing again
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnConnecting:
+		return "connecting"
+	case ConnConnected:
+		return "connected"
+	case ConnBackoff:
+		return "backoff"
+	default:
+		return "disconnected"
+	}
+}
+
+// ConnInfo describes the connection to a peer.
+type ConnInfo struct {
+	Address   string
+	State     ConnState
+	Failures  int       // Failed attempts in a row
+	RetryAt   time.Time // When a peer in backoff is dialed again
+	LastError string
+}
+
+// peerConn is the long-lived connection to one peer.
+type peerConn struct {
+	mutex     sync.Mutex
+	dialing   chan struct{} // Closed when the dial in progress ends, nil when not dialing
+	client    *rpc.Client
+	handshake *Handshake // Nil when no handshake was made
+	failures  int
+	retryAt   time.Time
+	lastError error
+}
+
+// ConnManager keeps one connection open to each peer we talk to and shares it between
+// calls. A peer whose connection fails is dialed again only after an exponential
+// backoff, and calls to it fail fast meanwhile, so broadcasts to dead nodes return
+// at once instead of piling up goroutines. Every call is bounded by callTimeout.
+type ConnManager struct {
+	Peers *PeerManager
+	Guard *PeerGuard // Refuses banned peers; nil for clients, which ban no one
+
+	mutex sync.Mutex
+	conns map[string]*peerConn
+}
+
+// NewConnManager creates a connection manager for the peers of pm.
+func NewConnManager(pm *PeerManager) *ConnManager {
+	return &ConnManager{
+		Peers: pm,
+		conns: make(map[string]*peerConn),
+	}
+}
+
+func (cm *ConnManager) conn(address string) *peerConn {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	pc := cm.conns[address]
+	if pc == nil {
+		pc = &peerConn{}
+		cm.conns[address] = pc
+	}
+	return pc
+}
+
+// client returns the connection to a peer, dialing it if needed. Callers arriving while
+// the peer is dialed wait for that dial instead of starting their own, and peers in
+// backoff fail with ErrPeerBackoff without being dialed.
+func (cm *ConnManager) client(address string, required []string) (*rpc.Client, error) {
+	if cm.Guard != nil && cm.Guard.IsBanned(cm.guardKey(address)) {
+		return nil, ErrPeerBanned
+	}
+	pc := cm.conn(address)
+	pc.mutex.Lock()
+	for pc.client == nil && pc.dialing != nil {
+		dialing := pc.dialing
+		pc.mutex.Unlock()
+		<-dialing
+		pc.mutex.Lock()
+	}
+
+	if pc.client == nil {
+		if time.Now().Before(pc.retryAt) {
+			pc.mutex.Unlock()
+			return nil, ErrPeerBackoff
+		}
+		pc.dialing = make(chan struct{})
+		pc.mutex.Unlock()
+
+		client, handshake, err := cm.Peers.dial(address)
+
+		pc.mutex.Lock()
+		close(pc.dialing)
+		pc.dialing = nil
+		if err != nil {
+			pc.fail(err)
+			pc.mutex.Unlock()
+			cm.Peers.MarkFailed(address)
+			return nil, err
+		}
+		pc.client = client
+		pc.handshake = handshake
+		pc.failures = 0
+		pc.lastError = nil
+	}
+	client, handshake := pc.client, pc.handshake
+	pc.mutex.Unlock()
+
+	if err := handshake.require(required); err != nil {
+		return nil, err
 	}
+	return client, nil
+}
+
+// fail closes the connection and schedules the next dial. Must be called with the
+// connection's mutex held.
+func (pc *peerConn) fail(err error) {
+	if pc.client != nil {
+		pc.client.Close()
+		pc.client = nil
+	}
+	pc.failures++
+	delay := maxReconnectDelay
+	if pc.failures < 16 {
+		if d := minReconnectDelay << (pc.failures - 1); d < delay {
+			delay = d
+		}
+	}
+	pc.retryAt = time.Now().Add(delay)
+	pc.lastError = err
+}
+
+// Call invokes method on a peer over its shared connection, connecting first if needed.
+// If the peer lacks one of the required capabilities the call is not made. A call that
+// times out or breaks the connection puts the peer in backoff; errors returned by the
+// peer itself leave the connection open.
+func (cm *ConnManager) Call(address, method string, args interface{}, reply interface{}, required ...string) error {
+	client, err := cm.client(address, required)
+	if err != nil {
+		return err
+	}
+
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	timer := time.NewTimer(callTimeout)
+	defer timer.Stop()
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-timer.C:
+		err = ErrCallTimeout
+	}
+
+	if _, isServerError := err.(rpc.ServerError); err == nil || isServerError {
+		cm.Peers.MarkSeen(address)
+		return err
+	}
+
+	pc := cm.conn(address)
+	pc.mutex.Lock()
+	if pc.client == client { // Not already replaced by another caller
+		pc.fail(err)
+	}
+	pc.mutex.Unlock()
+	cm.Peers.MarkFailed(address)
+	return err
+}
+
+// State returns the state of the connection to a peer.
+func (cm *ConnManager) State(address string) ConnState {
+	pc := cm.conn(address)
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	return pc.state()
+}
+
+// state must be called with the connection's mutex held.
+func (pc *peerConn) state() ConnState {
+	switch {
+	case pc.client != nil:
+		return ConnConnected
+	case pc.dialing != nil:
+		return ConnConnecting
+	case time.Now().Before(pc.retryAt):
+		return ConnBackoff
+	default:
+		return ConnDisconnected
+	}
+}
+
+// Connections describes the connection to every peer dialed so far.
+func (cm *ConnManager) Connections() []ConnInfo {
+	cm.mutex.Lock()
+	addresses := make([]string, 0, len(cm.conns))
+	for address := range cm.conns {
+		addresses = append(addresses, address)
+	}
+	cm.mutex.Unlock()
+	sort.Strings(addresses)
+
+	var infos []ConnInfo
+	for _, address := range addresses {
+		pc := cm.conn(address)
+		pc.mutex.Lock()
+		info := ConnInfo{Address: address, State: pc.state(), Failures: pc.failures, RetryAt: pc.retryAt}
+		if pc.lastError != nil {
+			info.LastError = pc.lastError.Error()
+		}
+		pc.mutex.Unlock()
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// Close closes every connection.
+func (cm *ConnManager) Close() {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	for address, pc := range cm.conns {
+		pc.mutex.Lock()
+		if pc.client != nil {
+			pc.client.Close()
+		}
+		pc.mutex.Unlock()
+		delete(cm.conns, address)
+	}
+}
+
+// Misbehaving charges score to a peer that sent us invalid data, and closes our
+// connection to it once it is banned.
+func (cm *ConnManager) Misbehaving(address string, score int, reason string) {
+	if cm.Guard == nil || !cm.Guard.Misbehaving(cm.guardKey(address), score, reason) {
+		return
+	}
+	pc := cm.conn(address)
+	pc.mutex.Lock()
+	if pc.client != nil {
+		pc.client.Close()
+		pc.client = nil
+	}
+	pc.mutex.Unlock()
+}
+
+// Handshake returns the handshake of the open connection to a peer, nil if there is none.
+func (cm *ConnManager) Handshake(address string) *Handshake {
+	pc := cm.conn(address)
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	if pc.client == nil {
+		return nil
+	}
+	return pc.handshake
+}
+
+// guardKey returns the identity misbehavior of a peer is charged to: its pinned ID if it
+// has one, as for peers connecting to us over TLS, otherwise its address.
+func (cm *ConnManager) guardKey(address string) string {
+	if id := cm.Peers.Identity(address); id != "" {
+		return id
+	}
+	return address
+}
+
+// Discover announces our address to the outbound peers and asks each of them for the
+// peers it knows. The peer table is saved afterwards.
+func (cm *ConnManager) Discover() {
+	var wg sync.WaitGroup
+	for _, address := range cm.Peers.Outbound() {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			addresses, err := cm.exchangePeers(address)
+			if err != nil {
+				if err != ErrPeerBackoff {
+					log.Printf("Error exchanging peers with %s: %v", address, err)
+				}
+				return
+			}
+			if added := cm.Peers.Add(addresses...); added > 0 {
+				log.Printf("Discovered %d new peers from %s", added, address)
+			}
+		}(address)
+	}
+	wg.Wait()
+
+	if err := cm.Peers.Save(); err != nil {
+		log.Printf("Error saving peer table: %v", err)
+	}
+}
+
+// exchangePeers announces our address to a peer and returns the addresses it knows.
+func (cm *ConnManager) exchangePeers(address string) ([]string, error) {
+	if self := cm.Peers.self; self != "" {
+		var reply string
+		if err := cm.Call(address, "Node.AddrAnnounce", []string{self}, &reply, CapabilityPeers); err != nil {
+			return nil, err
+		}
+	}
+	var addresses []string
+	if err := cm.Call(address, "Node.GetPeers", "discovery", &addresses, CapabilityPeers); err != nil {
+		return nil, err
+	}
+	if len(addresses) > maxPeerAddrs {
+		addresses = addresses[:maxPeerAddrs]
+	}
+	return addresses, nil
+}
+
+// Run discovers peers now and then at every interval.
+func (cm *ConnManager) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for {
+		cm.Discover()
+		<-ticker.C
+	}
+}
+
+// discoverNodes asks the seeds for their peers and returns every node found, for
+// clients that only need the current list once.
+func discoverNodes(seeds []string) []string {
+	conns := NewConnManager(NewPeerManager("", "", seeds))
+	defer conns.Close()
+
+	conns.Discover()
+	return conns.Peers.Addresses()
 }
 
 func (node *Node) Start() {
 	rpc.Register(node)
-	listener, err := net.Listen("tcp", node.Address)
+	listener, err := node.Transport.Listen(node.Address)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer listener.Close()
 
+	if node.JSONRPCAddress != "" {
+		go node.ServeJSONRPC(node.JSONRPCAddress)
+	}
+
 	// 启动一个协程来开始挖掘
 	go node.StartMining()
 
+	// 定期向已知节点宣告自身地址并交换节点列表
+	go node.Conns.Run(peerDiscoveryInterval)
+
 	// 启动定时同步任务
 	go func() {
 		syncTicker := time.NewTicker(30 * time.Second) // 每30秒同步一次
@@ -42,60 +434,92 @@ func (node *Node) Start() {
 			select {
 			case <-syncTicker.C:
 				node.SyncWithNetwork()
+				node.gossip.prune(node.Peers.Addresses()) // 清理已离开节点表的节点的已知清单
 			}
 		}
 	}()
 
+	node.Serve(listener, rpc.DefaultServer)
+}
+
+// Serve answers the RPCs of server on the connections accepted by listener until it is
+// closed. Every connection goes through the node's guard, which drops banned peers and
+// rate limits the others. Peers connecting over TLS are known by their identity.
+func (node *Node) Serve(listener net.Listener, server *rpc.Server) {
 	for {
 		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			log.Printf("Failed to accept connection: %v", err)
 			continue
 		}
-		go rpc.ServeConn(conn)
+		go func() {
+			id, err := remoteIdentity(conn)
+			if err != nil {
+				log.Printf("Refusing connection from %s: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			codec := newGuardedCodec(node.Guard, conn, id)
+			if node.Guard.IsBanned(codec.peer) && !codec.local { // Local callers may still lift the ban
+				conn.Close()
+				return
+			}
+			server.ServeCodec(codec)
+		}()
 	}
 }
 
-func (node *Node) AddBlockToBlockchain(block *Block) {
+// AddBlockToBlockchain appends the block if it extends the local tip and reports whether it did.
+// Blocks on another branch are left to the periodic sync, but still have to pass the
+// checks that do not depend on the parent.
+func (node *Node) AddBlockToBlockchain(block *Block) (bool, error) {
 	node.BlockchainMutex.Lock()
 	defer node.BlockchainMutex.Unlock()
 
-	if block.PrevBlockHash == nil || bytes.Equal(node.Blockchain.GetLatestBlock().Hash, block.PrevBlockHash) {
-		node.Blockchain.Blocks = append(node.Blockchain.Blocks, block)
-		node.Blockchain.Mempool.Clear() // Clear mempool after adding a block
+	if !bytes.Equal(node.Blockchain.GetLatestBlock().Hash, block.PrevBlockHash) {
+		return false, block.Validate(nil)
+	}
+	if err := node.Blockchain.AddBlock(block); err != nil {
+		return false, err
 	}
+	node.publishChainEvents()
+	node.abortMining() // The block being mined no longer extends the tip
+	return true, nil
 }
 
+// ReceiveNewBlock validates a block pushed by another node. Invalid blocks are
+// rejected with a BlockValidationError naming the rule they broke.
 func (node *Node) ReceiveNewBlock(block *Block, reply *string) error {
-	if block.IsValid() {
-		node.AddBlockToBlockchain(block)
-		*reply = "Block added to the blockchain"
-	} else {
+	added, err := node.AddBlockToBlockchain(block)
+	if err != nil {
 		*reply = "Invalid block"
-		log.Println("Received invalid block, rejecting")
+		log.Printf("Received invalid block, rejecting: %v", err)
+		return err
 	}
+	if !added {
+		*reply = "Block does not extend the local chain"
+		return nil
+	}
+	node.announce(InvItem{Type: InvBlock, Hash: block.Hash})
+	*reply = "Block added to the blockchain"
 	return nil
 }
 
+// BroadcastNewBlock announces a block of the local chain to the peers, which fetch it if
+// they lack it. Blocks the node does not hold are refused with ErrBlockNotFound, since
+// the peers could not fetch them; new blocks are sent with ReceiveNewBlock instead.
 func (node *Node) BroadcastNewBlock(block *Block, reply *string) error {
-	KnownNodes := readKnownNodesFromFile("nodes.txt")
-
-	for _, knownNode := range KnownNodes {
-		go func(knownNode string) {
-			client, err := rpc.Dial("tcp", knownNode)
-			if err != nil {
-				log.Printf("Error dialing known node %s: %v", knownNode, err)
-				return
-			}
-			defer client.Close()
-
-			var nodeReply string
-			err = client.Call("Node.ReceiveNewBlock", block, &nodeReply)
-			if err != nil {
-				log.Printf("Error broadcasting new block to node %s: %v", knownNode, err)
-			}
-		}(knownNode)
+	node.BlockchainMutex.Lock()
+	known := node.Blockchain.GetBlock(block.Hash) != nil
+	node.BlockchainMutex.Unlock()
+	if !known {
+		*reply = "Block is not in the local chain"
+		return ErrBlockNotFound
 	}
+	node.announce(InvItem{Type: InvBlock, Hash: block.Hash})
 	*reply = "Broadcast initiated"
 	return nil
 }
@@ -107,48 +531,128 @@ func (bc *Blockchain) GetLatestBlock() *Block {
 	return bc.Blocks[len(bc.Blocks)-1]
 }
 
+// GetBlock returns the block of the chain with the given hash, or nil.
+func (bc *Blockchain) GetBlock(hash []byte) *Block {
+	for i := len(bc.Blocks) - 1; i >= 0; i-- {
+		if bytes.Equal(bc.Blocks[i].Hash, hash) {
+			return bc.Blocks[i]
+		}
+	}
+	return nil
+}
+
+// ReceiveTransaction adds a transaction pushed by a wallet or another node to the mempool.
+// Transactions with a bad signature are refused with ErrMalformedTransaction, which counts
+// against the sender.
 func (node *Node) ReceiveTransaction(tx *Transaction, reply *string) error {
-	if tx.IsValid() {
-		// 将交易添加到交易池
-		node.Blockchain.AddTransactionToMempool(tx)
-		*reply = "Transaction added to mempool"
-	} else {
+	if !tx.IsValid() {
 		*reply = "Invalid transaction"
+		return ErrMalformedTransaction
+	}
+	if err := node.acceptTransaction(tx); err != nil {
+		*reply = "Transaction rejected: " + err.Error()
+		return nil
 	}
+	*reply = "Transaction added to mempool"
 	return nil
 }
 
+// acceptTransaction adds a transaction to the mempool and announces it to the peers.
+func (node *Node) acceptTransaction(tx *Transaction) error {
+	// 交易校验依赖链上状态，需与出块互斥
+	node.BlockchainMutex.Lock()
+	err := node.Blockchain.AddTransactionToMempool(tx)
+	node.BlockchainMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	node.Events.Publish(Event{Type: EventTx, TxID: tx.ID, Addresses: txAddresses(tx)})
+
+	// 转发给尚未知晓该交易的节点
+	node.announce(InvItem{Type: InvTx, Hash: tx.ID})
+	return nil
+}
+
+// MineBlockFromMempool mines a block from the mempool. The chain is only locked while the
+// template is built and the block is added, so blocks and transactions from other nodes
+// are processed during the search; a new tip aborts the search.
 func (node *Node) MineBlockFromMempool() {
 	node.BlockchainMutex.Lock()
-	defer node.BlockchainMutex.Unlock()
+	// 按手续费率从交易池中打包尽可能多的有效交易，coinbase 在最前
+	template := node.Blockchain.NewBlockTemplate(node.MinerAddress)
 
 	// 检查交易池是否有待处理的交易
-	if len(node.Blockchain.Mempool.Transactions) > 0 {
-		// 取出一个交易来挖掘新区块
-		transaction := node.Blockchain.Mempool.Transactions[0]
-		newBlock := NewBlock([]*Transaction{transaction}, node.Blockchain.GetLatestBlock().Hash)
+	if template.Included == 0 {
+		node.BlockchainMutex.Unlock()
+		return
+	}
+	// A block the chain would reject is not worth mining; drop the transaction at fault so
+	// the next template does without it
+	if offender, err := node.Blockchain.checkTemplate(template); err != nil {
+		if offender != nil {
+			node.Blockchain.EvictTransaction(offender)
+		}
+		node.BlockchainMutex.Unlock()
+		log.Printf("Discarding block template: %v", err)
+		return
+	}
+	newBlock := &Block{
+		Timestamp:     time.Now().Unix(),
+		Transactions:  template.Transactions,
+		PrevBlockHash: node.Blockchain.GetLatestBlock().Hash,
+		Difficulty:    node.Blockchain.NextDifficulty(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	node.miningMutex.Lock()
+	node.cancelMining = cancel
+	node.miningMutex.Unlock()
+	node.BlockchainMutex.Unlock()
 
-		// 挖掘新区块
-		newBlock.MineBlock()
+	// 挖掘新区块，不持有区块链锁
+	stats, err := newBlock.Mine(ctx, MiningWorkers)
+	node.abortMining()
+	if err != nil {
+		log.Printf("Mining aborted after %d hashes: the chain tip changed", stats.Hashes)
+		return
+	}
+	log.Printf("Mined block %x in %v (%.0f hashes/s)", newBlock.Hash, stats.Duration.Round(time.Millisecond), stats.Hashrate())
 
-		// 将新区块添加到区块链
-		node.Blockchain.Blocks = append(node.Blockchain.Blocks, newBlock)
+	// 将新区块添加到区块链，已打包的交易随之从交易池中移除
+	node.BlockchainMutex.Lock()
+	err = node.Blockchain.AddBlock(newBlock)
+	if err == nil {
+		node.publishChainEvents()
+	}
+	node.BlockchainMutex.Unlock()
+	if err != nil {
+		log.Printf("Discarding mined block: %v", err)
+		return
+	}
 
-		// 从交易池中移除已处理的交易
-		node.Blockchain.Mempool.Transactions = node.Blockchain.Mempool.Transactions[1:]
+	// 广播新区块
+	var reply string
+	if err := node.BroadcastNewBlock(newBlock, &reply); err != nil {
+		log.Printf("Failed to broadcast new block from node %v", err)
+	}
+}
 
-		// 广播新区块
-		var reply string
-		err := node.BroadcastNewBlock(newBlock, &reply)
-		if err != nil {
-			log.Printf("Failed to broadcast new block from node %v", err)
-		}
+// abortMining stops the search for the block currently being mined, if any.
+func (node *Node) abortMining() {
+	node.miningMutex.Lock()
+	defer node.miningMutex.Unlock()
+
+	if node.cancelMining != nil {
+		node.cancelMining()
+		node.cancelMining = nil
 	}
 }
 
 func (node *Node) StartMining() {
 	for {
 		node.MineBlockFromMempool()
+		node.BlockchainMutex.Lock()
+		node.Blockchain.ExpireMempool()
+		node.BlockchainMutex.Unlock()
 		time.Sleep(2 * time.Second) // 为简化起见，我们在这里设置了一个固定的延迟
 	}
 }
@@ -161,35 +665,127 @@ func (node *Node) GetCurrentBlockchain(request string, reply *[]*Block) error {
 	return nil
 }
 
-func (node *Node) UpdateLocalBlockchain(newBlocks []*Block) {
+// BlockInfo is a block of the chain with its height.
+type BlockInfo struct {
+	Height int
+	Block  *Block
+}
+
+// ChainTip describes the last block of the chain.
+type ChainTip struct {
+	Height int
+	Header BlockHeader
+	Work   string // Cumulative proof of work of the chain, in decimal
+}
+
+// GetBlockByHash returns the block of the chain with the given hash.
+func (node *Node) GetBlockByHash(hash []byte, reply *BlockInfo) error {
+	node.BlockchainMutex.Lock()
+	defer node.BlockchainMutex.Unlock()
+
+	for height, block := range node.Blockchain.Blocks {
+		if bytes.Equal(block.Hash, hash) {
+			*reply = BlockInfo{Height: height, Block: block}
+			return nil
+		}
+	}
+	return ErrBlockNotFound
+}
+
+// GetBlockByHeight returns the block of the chain at the given height, the genesis block
+// being at height 0.
+func (node *Node) GetBlockByHeight(height int, reply *BlockInfo) error {
 	node.BlockchainMutex.Lock()
 	defer node.BlockchainMutex.Unlock()
 
-	if len(newBlocks) > len(node.Blockchain.Blocks) {
-		node.Blockchain.Blocks = newBlocks
+	if height < 0 || height >= len(node.Blockchain.Blocks) {
+		return ErrBlockNotFound
 	}
+	*reply = BlockInfo{Height: height, Block: node.Blockchain.Blocks[height]}
+	return nil
 }
 
+// GetChainTip returns the last block of the chain.
+func (node *Node) GetChainTip(request string, reply *ChainTip) error {
+	node.BlockchainMutex.Lock()
+	defer node.BlockchainMutex.Unlock()
+
+	blocks := node.Blockchain.Blocks
+	if len(blocks) == 0 {
+		return ErrBlockNotFound
+	}
+	*reply = ChainTip{
+		Height: len(blocks) - 1,
+		Header: blocks[len(blocks)-1].Header(),
+		Work:   chainWork(blocks).String(),
+	}
+	return nil
+}
+
+// GetBalance returns the confirmed balance of an address.
+func (node *Node) GetBalance(address string, reply *int) error {
+	node.BlockchainMutex.Lock()
+	defer node.BlockchainMutex.Unlock()
+
+	*reply = node.Blockchain.GetBalance(address)
+	return nil
+}
+
+// GetMempool returns the transactions waiting to be mined.
+func (node *Node) GetMempool(request string, reply *[]*Transaction) error {
+	*reply = node.Blockchain.Mempool.GetTransactions()
+	return nil
+}
+
+// GetMerkleProof returns a proof that a transaction is included in the local chain.
+func (node *Node) GetMerkleProof(txID []byte, reply *MerkleProof) error {
+	node.BlockchainMutex.Lock()
+	defer node.BlockchainMutex.Unlock()
+
+	proof, err := node.Blockchain.FindMerkleProof(txID)
+	if err != nil {
+		return err
+	}
+	*reply = *proof
+	return nil
+}
+
+// SyncWithNetwork downloads the blocks of a chain carrying more cumulative proof of work
+// from the peers, headers first. Only one sync runs at a time.
 func (node *Node) SyncWithNetwork() {
-	knownNodes := readKnownNodesFromFile("nodes.txt")
+	if !node.syncMutex.TryLock() {
+		return
+	}
+	defer node.syncMutex.Unlock()
 
-	for _, knownNode := range knownNodes {
-		go func(knownNode string) {
-			client, err := rpc.Dial("tcp", knownNode)
-			if err != nil {
-				log.Printf("Error dialing known node %s: %v", knownNode, err)
-				return
-			}
-			defer client.Close()
+	syncer := &chainSync{conns: node.Conns, mutex: &node.BlockchainMutex, chain: node.Blockchain}
+	if syncer.Run() {
+		node.BlockchainMutex.Lock()
+		node.publishChainEvents()
+		node.BlockchainMutex.Unlock()
+		node.abortMining() // The block being mined no longer extends the tip
+	}
+}
 
-			var remoteBlocks []*Block
-			err = client.Call("Node.GetCurrentBlockchain", "sync_request", &remoteBlocks)
-			if err != nil {
-				log.Printf("Error getting blockchain from node %s: %v", knownNode, err)
-				return
-			}
+// GetPeers returns the addresses of the best maxPeerAddrs peers this node knows, itself
+// included.
+func (node *Node) GetPeers(request string, reply *[]string) error {
+	addresses := append([]string{node.Address}, node.Peers.Addresses()...)
+	if len(addresses) > maxPeerAddrs {
+		addresses = addresses[:maxPeerAddrs]
+	}
+	*reply = addresses
+	return nil
+}
 
-			node.UpdateLocalBlockchain(remoteBlocks)
-		}(knownNode)
+// AddrAnnounce adds the announced addresses to the peer table, at most maxPeerAddrs of them.
+func (node *Node) AddrAnnounce(addresses []string, reply *string) error {
+	if len(addresses) > maxPeerAddrs {
+		addresses = addresses[:maxPeerAddrs]
+	}
+	if added := node.Peers.Add(addresses...); added > 0 {
+		log.Printf("Learned %d new peers from an announcement", added)
 	}
+	*reply = "Addresses received"
+	return nil
 }
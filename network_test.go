@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+)
+
+// countingListener counts the connections it accepts.
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return conn, err
+}
+
+func TestConnManagerReusesConnections(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed with error: %v", err)
+	}
+	listener := &countingListener{Listener: inner}
+	defer listener.Close()
+
+	chain := newTestChain(t)
+	remote := NewNode(listener.Addr().String(), chain)
+	server := rpc.NewServer()
+	server.Register(remote)
+	go server.Accept(listener)
+
+	local := NewNode("127.0.0.1:4400", chain)
+	local.Peers.Add(remote.Address)
+	defer local.Conns.Close()
+	for i := 0; i < 3; i++ {
+		var blocks []*Block
+		if err := local.Conns.Call(remote.Address, "Node.GetCurrentBlockchain", "test", &blocks, CapabilityBlocks); err != nil {
+			t.Fatalf("Call() failed with error: %v", err)
+		}
+	}
+
+	if accepted := atomic.LoadInt32(&listener.accepted); accepted != 1 {
+		t.Errorf("Call() failed, expected one shared connection, got %d", accepted)
+	}
+	if state := local.Conns.State(remote.Address); state != ConnConnected {
+		t.Errorf("State() failed, expected connected, got %s", state)
+	}
+}
+
+func TestConnManagerBacksOffDeadPeers(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed with error: %v", err)
+	}
+	dead := listener.Addr().String()
+	listener.Close() // Nothing listens there any more
+
+	conns := NewConnManager(NewPeerManager("", "", []string{dead}))
+	var reply string
+	if err := conns.Call(dead, "Node.GetPeers", "test", &reply); err == nil {
+		t.Fatal("Call() failed, expected an error from a dead peer")
+	}
+	if err := conns.Call(dead, "Node.GetPeers", "test", &reply); !errors.Is(err, ErrPeerBackoff) {
+		t.Errorf("Call() failed, expected ErrPeerBackoff while waiting to reconnect, got %v", err)
+	}
+
+	infos := conns.Connections()
+	if len(infos) != 1 || infos[0].State != ConnBackoff || infos[0].Failures != 1 || infos[0].LastError == "" {
+		t.Errorf("Connections() failed, expected the dead peer in backoff, got %+v", infos)
+	}
+}
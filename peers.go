@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	peersFileName   = "peers.json"     // Peer table persisted in a node's data directory
+	defaultSeeds    = "127.0.0.1:3000" // Nodes asked for peers when no -seeds flag is given
+	maxPeerFailures = 3                // Failed contacts in a row before a peer is forgotten
+	maxKnownPeers   = 1000             // Peers kept in the table; further addresses are ignored until some are forgotten
+	maxPeerAddrs    = 100              // Addresses exchanged in one announcement or GetPeers reply
+)
+
+var MaxOutboundPeers = 8 // Peers a node or client talks to when broadcasting and syncing
+
+// Peer is an entry of the peer table.
+type Peer struct {
+	Address  string
+	LastSeen time.Time // Last successful contact, zero if never reached
+	Failures int       // Failed contacts since the last successful one
+	ID       string    `json:",omitempty"` // Identity presented over TLS, pinned at the first contact
+
+	// Learned from the peer's last handshake
+	Version      int      `json:",omitempty"`
+	BestHeight   int      `json:",omitempty"`
+	Capabilities []string `json:",omitempty"`
+}
+
+// PeerManager keeps the table of known peers. Peers are learned from the seed addresses
+// and from other peers through the GetPeers and AddrAnnounce RPCs, and the table is
+// saved in the node's data directory so a restarted node does not depend on its seeds.
+type PeerManager struct {
+	mutex    sync.Mutex
+	self     string // Own listening address, never added to the table; empty for clients
+	path     string // Where the table is saved, empty to keep it in memory
+	seeds    []string
+	peers    map[string]*Peer
+	rejected map[string]bool // Peers refused by a handshake, never added again
+
+	Transport *Transport // Opens the connections to the peers; nil for plain TCP
+
+	// LocalHandshake describes our chain to the peers we dial. When nil, as for clients
+	// without a chain, no handshake is made.
+	LocalHandshake func() Handshake
+}
+
+// NewPeerManager creates a peer manager for the node listening on self. The table saved
+// in dataDir is loaded if there is one; the seeds are always known.
+func NewPeerManager(self, dataDir string, seeds []string) *PeerManager {
+	pm := &PeerManager{
+		self:     self,
+		seeds:    seeds,
+		peers:    make(map[string]*Peer),
+		rejected: make(map[string]bool),
+	}
+	if dataDir != "" {
+		pm.path = filepath.Join(dataDir, peersFileName)
+		if err := pm.load(); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error loading peer table: %v", err)
+		}
+	}
+	pm.Add(seeds...)
+	return pm
+}
+
+// parseSeeds splits a comma separated list of addresses.
+func parseSeeds(list string) []string {
+	var seeds []string
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			seeds = append(seeds, address)
+		}
+	}
+	return seeds
+}
+
+// Add adds the addresses not yet in the table and returns how many were new. Addresses
+// that are not host:port are ignored, and so are all but the seeds once the table holds
+// maxKnownPeers peers.
+func (pm *PeerManager) Add(addresses ...string) int {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	added := 0
+	for _, address := range addresses {
+		if !validPeerAddress(address) || address == pm.self || pm.peers[address] != nil || pm.rejected[address] {
+			continue
+		}
+		if len(pm.peers) >= maxKnownPeers && !pm.isSeed(address) {
+			continue
+		}
+		pm.peers[address] = &Peer{Address: address}
+		added++
+	}
+	return added
+}
+
+// validPeerAddress reports whether address is a host and a port a peer can listen on.
+func validPeerAddress(address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	return err == nil && host != "" && port != ""
+}
+
+// MarkSeen records a successful contact with a peer.
+func (pm *PeerManager) MarkSeen(address string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if peer := pm.peers[address]; peer != nil {
+		peer.LastSeen = time.Now()
+		peer.Failures = 0
+	}
+}
+
+// MarkFailed records a failed contact with a peer. Peers failing too often in a row are
+// forgotten, except the seeds.
+func (pm *PeerManager) MarkFailed(address string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	peer := pm.peers[address]
+	if peer == nil {
+		return
+	}
+	peer.Failures++
+	if peer.Failures >= maxPeerFailures && !pm.isSeed(address) {
+		delete(pm.peers, address)
+	}
+}
+
+// pin records the identity a peer presented, and refuses it if the peer presented
+// another one before: the address now belongs to another node, or is impersonated.
+func (pm *PeerManager) pin(address, id string) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	peer := pm.peers[address]
+	if id == "" || peer == nil {
+		return nil
+	}
+	if peer.ID != "" && peer.ID != id {
+		return fmt.Errorf("%w: %s presented %s, expected %s", ErrIdentityMismatch, address, id, peer.ID)
+	}
+	peer.ID = id
+	return nil
+}
+
+// Known reports whether address is in the peer table.
+func (pm *PeerManager) Known(address string) bool {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	return pm.peers[address] != nil
+}
+
+// Identity returns the pinned identity of a peer, empty if none is known.
+func (pm *PeerManager) Identity(address string) string {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if peer := pm.peers[address]; peer != nil {
+		return peer.ID
+	}
+	return ""
+}
+
+// Reject forgets a peer that is not on our chain and refuses it from now on, even as a seed.
+func (pm *PeerManager) Reject(address string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if address == "" {
+		return
+	}
+	delete(pm.peers, address)
+	pm.rejected[address] = true
+}
+
+// MarkHandshake records what a peer told about itself in its handshake.
+func (pm *PeerManager) MarkHandshake(address string, handshake *Handshake) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if peer := pm.peers[address]; peer != nil {
+		peer.Version = handshake.Version
+		peer.BestHeight = handshake.BestHeight
+		peer.Capabilities = handshake.Capabilities
+	}
+}
+
+func (pm *PeerManager) isSeed(address string) bool {
+	for _, seed := range pm.seeds {
+		if seed == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Peers returns the whole peer table, reachable peers first and then the most recently seen.
+func (pm *PeerManager) Peers() []Peer {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	peers := make([]Peer, 0, len(pm.peers))
+	for _, peer := range pm.peers {
+		peers = append(peers, *peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].Failures != peers[j].Failures {
+			return peers[i].Failures < peers[j].Failures
+		}
+		if !peers[i].LastSeen.Equal(peers[j].LastSeen) {
+			return peers[i].LastSeen.After(peers[j].LastSeen)
+		}
+		return peers[i].Address < peers[j].Address
+	})
+	return peers
+}
+
+// Addresses returns the address of every known peer, best first.
+func (pm *PeerManager) Addresses() []string {
+	var addresses []string
+	for _, peer := range pm.Peers() {
+		addresses = append(addresses, peer.Address)
+	}
+	return addresses
+}
+
+// Outbound returns the best MaxOutboundPeers addresses, the peers blocks and
+// transactions are sent to.
+func (pm *PeerManager) Outbound() []string {
+	addresses := pm.Addresses()
+	if len(addresses) > MaxOutboundPeers {
+		addresses = addresses[:MaxOutboundPeers]
+	}
+	return addresses
+}
+
+// dial opens a connection to a peer within dialTimeout, checks the identity it presents
+// against the pinned one and makes the handshake, if we have one to make. The returned
+// handshake is nil when none was made.
+func (pm *PeerManager) dial(address string) (*rpc.Client, *Handshake, error) {
+	conn, id, err := pm.Transport.Dial(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := pm.pin(address, id); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	client := rpc.NewClient(conn)
+	if pm.LocalHandshake == nil {
+		return client, nil, nil
+	}
+
+	local := pm.LocalHandshake()
+	var remote Handshake
+	conn.SetDeadline(time.Now().Add(callTimeout))
+	if err := client.Call("Node.Handshake", local, &remote); err != nil {
+		client.Close()
+		if refusal := asHandshakeError(err); refusal != nil {
+			log.Printf("Rejecting peer %s: %v", address, refusal)
+			pm.Reject(address)
+			return nil, nil, refusal
+		}
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	if err := checkHandshake(&local, &remote); err != nil {
+		client.Close()
+		log.Printf("Rejecting peer %s: %v", address, err)
+		pm.Reject(address)
+		return nil, nil, err
+	}
+	pm.MarkHandshake(address, &remote)
+	return client, &remote, nil
+}
+
+// Save writes the peer table to the data directory. The new table is written to a
+// temporary file first, so a crash leaves either the old or the new one.
+func (pm *PeerManager) Save() error {
+	if pm.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(pm.Peers(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(pm.path), 0755); err != nil {
+		return err
+	}
+	tmpPath := pm.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, pm.path)
+}
+
+// load reads the peer table saved in the data directory.
+func (pm *PeerManager) load() error {
+	data, err := os.ReadFile(pm.path)
+	if err != nil {
+		return err
+	}
+	var peers []Peer
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return err
+	}
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	for i := range peers {
+		if len(pm.peers) >= maxKnownPeers {
+			break
+		}
+		if validPeerAddress(peers[i].Address) && peers[i].Address != pm.self {
+			pm.peers[peers[i].Address] = &peers[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPeerTableSurvivesRestart(t *testing.T) {
+	dataDir := t.TempDir()
+	seed := "127.0.0.1:4001"
+
+	peers := NewPeerManager("127.0.0.1:4000", dataDir, []string{seed})
+	if added := peers.Add("127.0.0.1:4000", "127.0.0.1:4002", "127.0.0.1:4002", "127.0.0.1:4003"); added != 2 {
+		t.Errorf("Add() failed, expected 2 new peers without ourselves and duplicates, got %d", added)
+	}
+	peers.MarkSeen("127.0.0.1:4003")
+	if err := peers.Save(); err != nil {
+		t.Fatalf("Save() failed with error: %v", err)
+	}
+
+	restarted := NewPeerManager("127.0.0.1:4000", dataDir, nil)
+	addresses := restarted.Addresses()
+	if len(addresses) != 3 || addresses[0] != "127.0.0.1:4003" {
+		t.Errorf("NewPeerManager() failed, expected the saved peers with the last seen first, got %v", addresses)
+	}
+}
+
+func TestPeerManagerOutboundLimit(t *testing.T) {
+	defer func(limit int) { MaxOutboundPeers = limit }(MaxOutboundPeers)
+	MaxOutboundPeers = 2
+
+	seed := "127.0.0.1:4101"
+	peers := NewPeerManager("", "", []string{seed})
+	peers.Add("127.0.0.1:4102", "127.0.0.1:4103")
+	peers.MarkSeen("127.0.0.1:4103")
+	for i := 0; i < maxPeerFailures; i++ {
+		peers.MarkFailed(seed)
+		peers.MarkFailed("127.0.0.1:4102")
+	}
+
+	outbound := peers.Outbound()
+	if len(outbound) != 2 || outbound[0] != "127.0.0.1:4103" || outbound[1] != seed {
+		t.Errorf("Outbound() failed, expected the reachable peer then the seed, got %v", outbound)
+	}
+	if len(peers.Addresses()) != 2 {
+		t.Error("MarkFailed() failed, an unreachable peer that is not a seed should be forgotten")
+	}
+}
+
+func TestPeerDiscovery(t *testing.T) {
+	node := startTestNode(t, newTestChain(t))
+	node.Peers.Add("127.0.0.1:4201")
+
+	peers := NewPeerManager("127.0.0.1:4200", "", []string{node.Address})
+	conns := NewConnManager(peers)
+	defer conns.Close()
+	conns.Discover()
+
+	addresses := peers.Addresses()
+	if len(addresses) != 2 || addresses[0] != node.Address {
+		t.Errorf("Discover() failed, expected the seed and its peer, got %v", addresses)
+	}
+	if !contains(node.Peers.Addresses(), "127.0.0.1:4200") {
+		t.Error("Discover() failed, our address was not announced to the seed")
+	}
+}
+
+func TestPeerManagerIgnoresInvalidAddressesWhenFull(t *testing.T) {
+	seed := "127.0.0.1:4301"
+	peers := NewPeerManager("", "", nil)
+	if added := peers.Add("", "no-port", ":4300", "127.0.0.1:"); added != 0 {
+		t.Errorf("Add() failed, expected addresses that are not host:port to be ignored, added %d", added)
+	}
+
+	for i := 0; len(peers.Addresses()) < maxKnownPeers; i++ {
+		peers.Add(fmt.Sprintf("10.0.%d.%d:3000", i/256, i%256))
+	}
+	if peers.Add("10.1.0.1:3000") != 0 {
+		t.Error("Add() failed, expected a full table to ignore new peers")
+	}
+	peers.seeds = []string{seed}
+	if peers.Add(seed) != 1 {
+		t.Error("Add() failed, expected a seed to be added to a full table")
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+)
+
+var ErrNoCommonAncestor = errors.New("chain does not share the local genesis block")
+
+// connectBlock validates a block against the tip and applies it to the in-memory state.
+func (bc *Blockchain) connectBlock(block *Block) error {
+	if len(bc.Blocks) > 0 {
+		if err := checkBlock(block, bc.Blocks); err != nil {
+			return err
+		}
+	}
+	updatedNonces, err := blockNonces(bc.Nonces, block)
+	if err != nil {
+		return invalidBlock(RuleTransactions, "%v", err)
+	}
+	undo, err := bc.UTXOSet.ApplyBlock(block, len(bc.Blocks))
+	if err != nil {
+		return invalidBlock(RuleTransactions, "%v", err)
+	}
+
+	for address, nonce := range updatedNonces {
+		bc.Nonces[address] = nonce
+	}
+	bc.Blocks = append(bc.Blocks, block)
+	bc.undos = append(bc.undos, undo)
+	return nil
+}
+
+// disconnectBlock removes the tip and reverts its effect on the UTXO set and nonces.
+func (bc *Blockchain) disconnectBlock() *Block {
+	tip := len(bc.Blocks) - 1
+	block := bc.Blocks[tip]
+	bc.UTXOSet.Rollback(bc.undos[tip])
+
+	// Nonces in a block run in sequence, so the first one of each sender is the one to restore
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		if tx.From == "" {
+			continue
+		}
+		if tx.Nonce == 0 {
+			delete(bc.Nonces, tx.From)
+		} else {
+			bc.Nonces[tx.From] = tx.Nonce
+		}
+	}
+
+	bc.Blocks = bc.Blocks[:tip]
+	bc.undos = bc.undos[:tip]
+	return block
+}
+
+// Reorganize switches to blocks if they carry more cumulative proof of work than the
+// local chain and reports whether it did.
+//
+// State is rolled back to the last block both chains share and the new branch is then
+// applied block by block with full validation. If any block is rejected, or the new chain
+// cannot be stored, the original chain is restored. Transactions from abandoned blocks that are still valid on the new
+// chain are returned to the mempool together with the pending ones.
+func (bc *Blockchain) Reorganize(blocks []*Block) (bool, error) {
+	fork := 0
+	for fork < len(bc.Blocks) && fork < len(blocks) && bytes.Equal(bc.Blocks[fork].Hash, blocks[fork].Hash) {
+		fork++
+	}
+	if fork == 0 {
+		return false, ErrNoCommonAncestor
+	}
+	if chainWork(blocks[fork:]).Cmp(chainWork(bc.Blocks[fork:])) <= 0 {
+		return false, nil
+	}
+
+	original := append([]*Block(nil), bc.Blocks...)
+	var abandoned []*Block
+	for len(bc.Blocks) > fork {
+		abandoned = append([]*Block{bc.disconnectBlock()}, abandoned...)
+	}
+
+	// restore disconnects the new branch and reconnects the abandoned blocks
+	restore := func(reorgErr error) error {
+		for len(bc.Blocks) > fork {
+			bc.disconnectBlock()
+		}
+		for _, block := range abandoned {
+			if err := bc.connectBlock(block); err != nil {
+				// Reconnecting should not fail, but if it does the whole state is rebuilt
+				// from the original chain
+				return bc.rebuild(original, reorgErr)
+			}
+		}
+		return reorgErr
+	}
+
+	for height := fork; height < len(blocks); height++ {
+		if err := bc.connectBlock(blocks[height]); err != nil {
+			return false, restore(fmt.Errorf("block %d: %v", height, err))
+		}
+	}
+
+	if bc.store != nil {
+		var err error
+		if len(abandoned) == 0 {
+			// The chain was only extended, so the stored blocks stay and the new ones follow
+			for _, block := range blocks[fork:] {
+				if err = bc.store.Append(block); err != nil {
+					break
+				}
+			}
+		} else {
+			err = bc.store.Replace(bc.Blocks)
+		}
+		if err != nil {
+			// The write may have stopped partway, put back the original chain
+			if restoreErr := bc.store.Replace(original); restoreErr != nil {
+				log.Printf("Failed to restore the stored blockchain: %v", restoreErr)
+			}
+			return false, restore(fmt.Errorf("failed to persist blockchain: %v", err))
+		}
+	}
+
+	var orphaned []*Transaction
+	for _, block := range abandoned {
+		orphaned = append(orphaned, block.Transactions...)
+	}
+	returned := bc.resubmit(orphaned)
+
+	log.Printf("Reorganized at height %d: %d blocks abandoned, %d blocks applied, %d transactions returned to the mempool",
+		fork, len(abandoned), len(blocks)-fork, returned)
+	return true, nil
+}
+
+// rebuild puts back original, the chain held before a rejected reorg, and its state from
+// scratch. It returns reorgErr, the reason the reorg was rejected, or the rebuild error.
+func (bc *Blockchain) rebuild(original []*Block, reorgErr error) error {
+	utxoSet, nonces, undos, err := chainState(original)
+	if err != nil {
+		return fmt.Errorf("%v, and restoring the original chain failed: %v", reorgErr, err)
+	}
+	bc.Blocks = original
+	bc.UTXOSet = utxoSet
+	bc.Nonces = nonces
+	bc.undos = undos
+	log.Printf("Rebuilt the chain state after a rejected reorg: %v", reorgErr)
+	return reorgErr
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReorganizeSwitchesToMoreWork(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+
+	genesis := NewBlock([]*Transaction{NewTransaction("", alice.Address(), 100)}, []byte{})
+	blockchain := &Blockchain{Mempool: NewMempool()}
+	if err := blockchain.ReplaceBlocks([]*Block{genesis}); err != nil {
+		t.Fatalf("ReplaceBlocks() failed with error: %v", err)
+	}
+
+	tx, _ := NewSignedTransaction(alice, bob.Address(), 10, 0)
+	if err := blockchain.AddBlock(NewBlock([]*Transaction{tx}, genesis.Hash)); err != nil {
+		t.Fatalf("AddBlock() failed with error: %v", err)
+	}
+	original := append([]*Block{}, blockchain.Blocks...)
+
+	// A competing branch of two empty blocks carries more work
+	b1 := NewBlock([]*Transaction{}, genesis.Hash)
+	b2 := NewBlock([]*Transaction{}, b1.Hash)
+	branch := []*Block{genesis, b1, b2}
+
+	reorganized, err := blockchain.Reorganize(branch)
+	if err != nil || !reorganized {
+		t.Fatalf("Reorganize() failed, expected a reorg, got %v, %v", reorganized, err)
+	}
+	if !bytes.Equal(blockchain.GetLatestBlock().Hash, b2.Hash) {
+		t.Error("Reorganize() failed, the new branch is not the tip")
+	}
+	if blockchain.GetBalance(bob.Address()) != 0 || blockchain.GetBalance(alice.Address()) != 100 {
+		t.Error("Reorganize() failed, the abandoned transfer is still applied")
+	}
+	if blockchain.NextNonce(alice.Address()) != 0 {
+		t.Errorf("Reorganize() failed, expected nonce 0, got %d", blockchain.NextNonce(alice.Address()))
+	}
+	if blockchain.Mempool.Len() != 1 || !blockchain.Mempool.Contains(tx.ID) {
+		t.Error("Reorganize() failed, the abandoned transaction was not returned to the mempool")
+	}
+
+	// The original chain now has less work and is ignored
+	if reorganized, err := blockchain.Reorganize(original); err != nil || reorganized {
+		t.Errorf("Reorganize() failed, a chain with less work should be ignored, got %v, %v", reorganized, err)
+	}
+}
+
+func TestReorganizeRestoresChainOnInvalidBranch(t *testing.T) {
+	blockchain := NewBlockchain("")
+	genesis := blockchain.GetLatestBlock()
+	tip := NewBlock([]*Transaction{}, genesis.Hash)
+	if err := blockchain.AddBlock(tip); err != nil {
+		t.Fatalf("AddBlock() failed with error: %v", err)
+	}
+
+	b1 := NewBlock([]*Transaction{}, genesis.Hash)
+	b2 := NewBlock([]*Transaction{}, b1.Hash)
+	b2.Nonce++ // Breaks the hash
+	if _, err := blockchain.Reorganize([]*Block{genesis, b1, b2}); err == nil {
+		t.Error("Reorganize() failed, an invalid branch should be rejected")
+	}
+	if len(blockchain.Blocks) != 2 || !bytes.Equal(blockchain.GetLatestBlock().Hash, tip.Hash) {
+		t.Error("Reorganize() failed, the original chain was not restored")
+	}
+
+	if _, err := blockchain.Reorganize([]*Block{NewBlock([]*Transaction{}, []byte{})}); err != ErrNoCommonAncestor {
+		t.Errorf("Reorganize() failed, expected ErrNoCommonAncestor, got %v", err)
+	}
+}
+
+func TestReorganizeKeepsChainWhenStoreFails(t *testing.T) {
+	blockchain := NewBlockchain(t.TempDir())
+	genesis := blockchain.GetLatestBlock()
+	blockchain.Close() // Every write to the store now fails
+
+	reorganized, err := blockchain.Reorganize([]*Block{genesis, NewBlock([]*Transaction{}, genesis.Hash)})
+	if err == nil || reorganized {
+		t.Fatalf("Reorganize() failed, expected the store error and no reorg, got %v, %v", reorganized, err)
+	}
+	if len(blockchain.Blocks) != 1 || blockchain.GetLatestBlock() != genesis {
+		t.Errorf("Reorganize() failed, the chain in memory changed although it was not stored: %d blocks", len(blockchain.Blocks))
+	}
+}
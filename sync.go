@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+const (
+	maxHeadersPerRequest = 2000 // Headers returned by one GetHeaders call
+	maxBlocksPerRequest  = 16   // Blocks requested, and returned, in one GetBlocks call
+	denseLocatorLength   = 10   // Locator entries for the most recent blocks, one per block
+)
+
+var ErrUnknownHeaders = errors.New("headers do not connect to the local chain")
+
+// BlockHeader is a block without its transactions. The hash commits to the header
+// alone, so a chain of headers can be checked before any block is downloaded.
+type BlockHeader struct {
+	Timestamp     int64
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Hash          []byte
+	Nonce         int
+	Difficulty    int
+}
+
+// Header returns the header of the block.
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{
+		Timestamp:     b.Timestamp,
+		PrevBlockHash: b.PrevBlockHash,
+		MerkleRoot:    b.MerkleRoot,
+		Hash:          b.Hash,
+		Nonce:         b.Nonce,
+		Difficulty:    b.Difficulty,
+	}
+}
+
+// block returns a block with the header and no transactions, for the header checks.
+func (h BlockHeader) block() *Block {
+	return &Block{
+		Timestamp:     h.Timestamp,
+		PrevBlockHash: h.PrevBlockHash,
+		MerkleRoot:    h.MerkleRoot,
+		Hash:          h.Hash,
+		Nonce:         h.Nonce,
+		Difficulty:    h.Difficulty,
+	}
+}
+
+// GetHeadersRequest asks for the headers following the last block the requester shares
+// with the responder.
+type GetHeadersRequest struct {
+	Locator [][]byte // Hashes of the requester's chain, newest first, see blockLocator
+}
+
+// blockLocator returns hashes describing chain, so a peer can find the last block it
+// shares with us in one round trip: the newest denseLocatorLength blocks one by one,
+// then going back with doubling steps, always ending with the genesis block.
+func blockLocator(chain []*Block) [][]byte {
+	var locator [][]byte
+	step := 1
+	for height := len(chain) - 1; height > 0; height -= step {
+		locator = append(locator, chain[height].Hash)
+		if len(locator) >= denseLocatorLength {
+			step *= 2
+		}
+	}
+	if len(chain) > 0 {
+		locator = append(locator, chain[0].Hash)
+	}
+	return locator
+}
+
+// locateFork returns the height of the first locator entry found in chain, or -1.
+func locateFork(chain []*Block, locator [][]byte) int {
+	heights := make(map[string]int, len(chain))
+	for height, block := range chain {
+		heights[string(block.Hash)] = height
+	}
+	for _, hash := range locator {
+		if height, ok := heights[string(hash)]; ok {
+			return height
+		}
+	}
+	return -1
+}
+
+// GetHeaders returns up to maxHeadersPerRequest headers following the last block of
+// the request's locator that is on this node's chain.
+func (node *Node) GetHeaders(request GetHeadersRequest, reply *[]BlockHeader) error {
+	node.BlockchainMutex.Lock()
+	defer node.BlockchainMutex.Unlock()
+
+	chain := node.Blockchain.Blocks
+	fork := locateFork(chain, request.Locator)
+	if fork < 0 {
+		return ErrUnknownHeaders
+	}
+	end := fork + 1 + maxHeadersPerRequest
+	if end > len(chain) {
+		end = len(chain)
+	}
+	for _, block := range chain[fork+1 : end] {
+		*reply = append(*reply, block.Header())
+	}
+	return nil
+}
+
+// GetBlocks returns the blocks of this node's chain with the requested hashes, in the
+// requested order. At most maxBlocksPerRequest blocks are returned.
+func (node *Node) GetBlocks(hashes [][]byte, reply *[]*Block) error {
+	if len(hashes) > maxBlocksPerRequest {
+		hashes = hashes[:maxBlocksPerRequest]
+	}
+
+	node.BlockchainMutex.Lock()
+	defer node.BlockchainMutex.Unlock()
+
+	for _, hash := range hashes {
+		block := node.Blockchain.GetBlock(hash)
+		if block == nil {
+			return fmt.Errorf("unknown block %x", hash)
+		}
+		*reply = append(*reply, block)
+	}
+	return nil
+}
+
+// connectHeaders checks that headers extend chain and returns the height of the block
+// they start from, with the headers as blocks without transactions. Every header is
+// validated against the ones before it, including proof of work and the retarget rule,
+// before any block is downloaded.
+func connectHeaders(chain []*Block, headers []BlockHeader) (int, []*Block, error) {
+	if len(headers) == 0 {
+		return 0, nil, errors.New("no headers")
+	}
+	fork := locateFork(chain, [][]byte{headers[0].PrevBlockHash})
+	if fork < 0 {
+		return 0, nil, ErrUnknownHeaders
+	}
+
+	branch := make([]*Block, 0, len(headers))
+	candidate := append([]*Block(nil), chain[:fork+1]...)
+	for _, header := range headers {
+		block := header.block()
+		if err := checkHeader(block, candidate); err != nil {
+			return 0, nil, fmt.Errorf("header %x: %v", header.Hash, err)
+		}
+		candidate = append(candidate, block)
+		branch = append(branch, block)
+	}
+	return fork, branch, nil
+}
+
+// chainSync brings a chain up to the chain with the most work among the peers,
+// headers first: the peers are asked for the headers following our block locator,
+// the best header chain is validated, and only its missing blocks are downloaded,
+// in batches spread over every peer that has them.
+type chainSync struct {
+	conns *ConnManager
+	mutex sync.Locker // Guards chain
+	chain *Blockchain
+}
+
+// Run syncs until no peer offers more work and reports whether the chain changed.
+func (s *chainSync) Run() bool {
+	updated := false
+	for s.round() {
+		updated = true
+	}
+	return updated
+}
+
+// headerOffer is the reply of one peer to GetHeaders.
+type headerOffer struct {
+	peer    string
+	headers []BlockHeader
+}
+
+// round downloads at most maxHeadersPerRequest blocks and reports whether the chain
+// was extended or reorganized.
+func (s *chainSync) round() bool {
+	s.mutex.Lock()
+	local := append([]*Block(nil), s.chain.Blocks...)
+	s.mutex.Unlock()
+
+	offers := s.requestHeaders(blockLocator(local))
+
+	var best []*Block
+	var bestFork int
+	bestWork := chainWork(local)
+	for _, offer := range offers {
+		fork, branch, err := connectHeaders(local, offer.headers)
+		if err != nil {
+			log.Printf("Ignoring headers from node %s: %v", offer.peer, err)
+			if err != ErrUnknownHeaders {
+				s.conns.Misbehaving(offer.peer, scoreInvalidBlock, err.Error())
+			}
+			continue
+		}
+		work := chainWork(local[:fork+1])
+		work.Add(work, chainWork(branch))
+		if work.Cmp(bestWork) > 0 {
+			best, bestFork, bestWork = branch, fork, work
+		}
+	}
+	if best == nil {
+		return false
+	}
+
+	// Any peer whose headers reach the same tip has every block of the branch
+	tip := best[len(best)-1].Hash
+	var sources []string
+	for _, offer := range offers {
+		for _, header := range offer.headers {
+			if bytes.Equal(header.Hash, tip) {
+				sources = append(sources, offer.peer)
+				break
+			}
+		}
+	}
+
+	blocks, err := s.downloadBlocks(local, best, sources)
+	if err != nil {
+		log.Printf("Sync aborted: %v", err)
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	updated, err := s.chain.Reorganize(append(local[:bestFork+1:bestFork+1], blocks...))
+	if err != nil {
+		log.Printf("Rejecting synced blocks: %v", err)
+	}
+	return updated
+}
+
+// requestHeaders asks every outbound peer in parallel for the headers following locator.
+func (s *chainSync) requestHeaders(locator [][]byte) []headerOffer {
+	var offers []headerOffer
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, peer := range s.conns.Peers.Outbound() {
+		if s.conns.State(peer) == ConnBackoff {
+			continue
+		}
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			var headers []BlockHeader
+			err := s.conns.Call(peer, "Node.GetHeaders", GetHeadersRequest{Locator: locator}, &headers, CapabilityBlocks)
+			if err != nil {
+				log.Printf("Error getting headers from node %s: %v", peer, err)
+				return
+			}
+			if len(headers) > 0 {
+				mutex.Lock()
+				offers = append(offers, headerOffer{peer: peer, headers: headers})
+				mutex.Unlock()
+			}
+		}(peer)
+	}
+	wg.Wait()
+	return offers
+}
+
+// downloadBlocks fetches the blocks of branch, reusing those already in local. Batches
+// of maxBlocksPerRequest blocks are shared out between the sources, one worker per
+// source; a batch a source fails to deliver is handed to the others.
+func (s *chainSync) downloadBlocks(local, branch []*Block, sources []string) ([]*Block, error) {
+	have := make(map[string]*Block, len(local))
+	for _, block := range local {
+		have[string(block.Hash)] = block
+	}
+	blocks := make([]*Block, len(branch))
+	var missing []int
+	for i, header := range branch {
+		if block := have[string(header.Hash)]; block != nil {
+			blocks[i] = block
+		} else {
+			missing = append(missing, i)
+		}
+	}
+
+	var batches [][]int
+	for start := 0; start < len(missing); start += maxBlocksPerRequest {
+		end := start + maxBlocksPerRequest
+		if end > len(missing) {
+			end = len(missing)
+		}
+		batches = append(batches, missing[start:end])
+	}
+
+	pending := batches
+	for len(pending) > 0 {
+		if len(sources) == 0 {
+			return nil, errors.New("no peer delivered the missing blocks")
+		}
+		queue := make(chan []int, len(pending))
+		for _, batch := range pending {
+			queue <- batch
+		}
+		close(queue)
+
+		var failedMutex sync.Mutex
+		failed := make(map[string]bool)
+		var wg sync.WaitGroup
+		for _, peer := range sources {
+			wg.Add(1)
+			go func(peer string) {
+				defer wg.Done()
+				for batch := range queue {
+					if err := s.fetchBatch(peer, branch, batch, blocks); err != nil {
+						log.Printf("Error downloading blocks from node %s: %v", peer, err)
+						failedMutex.Lock()
+						failed[peer] = true
+						failedMutex.Unlock()
+						return
+					}
+				}
+			}(peer)
+		}
+		wg.Wait()
+
+		var alive []string
+		for _, peer := range sources {
+			if !failed[peer] {
+				alive = append(alive, peer)
+			}
+		}
+		sources = alive
+
+		var next [][]int
+		for _, batch := range pending {
+			if blocks[batch[0]] == nil {
+				next = append(next, batch)
+			}
+		}
+		pending = next
+	}
+	return blocks, nil
+}
+
+// fetchBatch downloads the blocks of branch at the given indexes into blocks. Each block
+// must match the header it was requested for.
+func (s *chainSync) fetchBatch(peer string, branch []*Block, batch []int, blocks []*Block) error {
+	hashes := make([][]byte, len(batch))
+	for i, index := range batch {
+		hashes[i] = branch[index].Hash
+	}
+
+	var reply []*Block
+	if err := s.conns.Call(peer, "Node.GetBlocks", hashes, &reply, CapabilityBlocks); err != nil {
+		return err
+	}
+	if len(reply) != len(batch) {
+		return fmt.Errorf("got %d blocks, requested %d", len(reply), len(batch))
+	}
+	for i, block := range reply {
+		if !bytes.Equal(block.Hash, hashes[i]) || !bytes.Equal(block.ComputeHash(), hashes[i]) {
+			s.conns.Misbehaving(peer, scoreInvalidBlock, "block does not match its header")
+			return fmt.Errorf("block %x does not match the requested header", block.Hash)
+		}
+	}
+	// Indexes are only written once the whole batch is good, so a retried batch is all or nothing
+	for i, index := range batch {
+		blocks[index] = reply[i]
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// extendChain mines count empty blocks on top of blocks.
+func extendChain(blocks []*Block, count int) []*Block {
+	chain := append([]*Block{}, blocks...)
+	for i := 0; i < count; i++ {
+		chain = append(chain, NewBlock([]*Transaction{}, chain[len(chain)-1].Hash))
+	}
+	return chain
+}
+
+func TestBlockLocator(t *testing.T) {
+	chain := make([]*Block, 100)
+	for height := range chain {
+		chain[height] = &Block{Hash: []byte{byte(height)}}
+	}
+
+	locator := blockLocator(chain)
+	if !bytes.Equal(locator[0], chain[99].Hash) || !bytes.Equal(locator[9], chain[90].Hash) || !bytes.Equal(locator[10], chain[88].Hash) {
+		t.Errorf("blockLocator() failed, expected the newest blocks one by one, got %v", locator)
+	}
+	if !bytes.Equal(locator[len(locator)-1], chain[0].Hash) || len(locator) > 20 {
+		t.Errorf("blockLocator() failed, expected a short locator ending with the genesis block, got %v", locator)
+	}
+
+	// A peer that only has the first 50 blocks starts from the last locator entry it shares
+	if fork := locateFork(chain[:50], locator); fork != 28 {
+		t.Errorf("locateFork() failed, expected 28, got %d", fork)
+	}
+}
+
+func TestConnectHeadersRejectsInvalidHeaders(t *testing.T) {
+	genesis := NewBlock([]*Transaction{}, []byte{})
+	chain := extendChain([]*Block{genesis}, 3)
+
+	var headers []BlockHeader
+	for _, block := range chain[1:] {
+		headers = append(headers, block.Header())
+	}
+	if fork, branch, err := connectHeaders(chain[:1], headers); err != nil || fork != 0 || len(branch) != 3 {
+		t.Fatalf("connectHeaders() failed, expected 3 headers after the genesis block, got %d, %d, %v", fork, len(branch), err)
+	}
+
+	headers[1].Nonce++
+	if _, _, err := connectHeaders(chain[:1], headers); err == nil {
+		t.Error("connectHeaders() failed, a tampered header was accepted")
+	}
+	otherGenesis := NewBlock([]*Transaction{NewTransaction("", NewWallet().Address(), 100)}, []byte{})
+	if _, _, err := connectHeaders([]*Block{otherGenesis}, headers[:1]); !errors.Is(err, ErrUnknownHeaders) {
+		t.Errorf("connectHeaders() failed, expected ErrUnknownHeaders, got %v", err)
+	}
+}
+
+func TestSyncDownloadsMissingBlocksFromPeers(t *testing.T) {
+	defer func(interval int) { RetargetInterval = interval }(RetargetInterval)
+	RetargetInterval = 1000
+
+	genesis := NewBlock([]*Transaction{}, []byte{})
+	shared := extendChain([]*Block{genesis}, 2)
+	remoteChain := extendChain(shared, 2*maxBlocksPerRequest+3)
+
+	local := NewNode("127.0.0.1:4500", newChainFrom(t, shared))
+	local.Blockchain.AddBlock(NewBlock([]*Transaction{}, shared[len(shared)-1].Hash)) // A shorter branch of our own
+	for i := 0; i < 2; i++ {
+		remote := startTestNode(t, newChainFrom(t, remoteChain))
+		local.Peers.Add(remote.Address)
+	}
+	defer local.Conns.Close()
+
+	local.SyncWithNetwork()
+	if len(local.Blockchain.Blocks) != len(remoteChain) || !bytes.Equal(local.Blockchain.GetLatestBlock().Hash, remoteChain[len(remoteChain)-1].Hash) {
+		t.Fatalf("SyncWithNetwork() failed, expected %d blocks, got %d", len(remoteChain), len(local.Blockchain.Blocks))
+	}
+}
+
+func newChainFrom(t *testing.T, blocks []*Block) *Blockchain {
+	blockchain := &Blockchain{Mempool: NewMempool()}
+	if err := blockchain.ReplaceBlocks(blocks); err != nil {
+		t.Fatalf("ReplaceBlocks() failed with error: %v", err)
+	}
+	return blockchain
+}
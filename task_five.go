@@ -4,34 +4,38 @@ import (
 	"fmt"
 	"log"
 	"net/rpc"
+	"strings"
 	"time"
 )
 
 func performTaskFive() {
 	defer cleanupChildProcesses()
 
+	seeds := localAddresses(9401, 2)
+	seedList := strings.Join(seeds, ",")
+
 	// Start the wallet application
-	go startProcess("wallet", "8080")
+	go startProcess("wallet", "8080", "-seeds", seedList)
 
 	// Start 2 nodes
 	for i := 1; i <= 2; i++ {
 		port := 9400 + i
 		time.Sleep(1 * time.Second)
-		go startProcess("node", fmt.Sprintf("%d", port))
+		go startProcess("node", fmt.Sprintf("%d", port), "-seeds", seedList)
 	}
 	// Ensure nodes are up and running
 	time.Sleep(10 * time.Second)
 
 	// 创建分叉
-	createForkAndTest()
+	createForkAndTest(seeds)
 
 	log.Println("Task Five Demo End.")
 
 }
 
-func createForkAndTest() {
+func createForkAndTest(seeds []string) {
 	// 读取已知节点的地址
-	nodeAddresses := readKnownNodesFromFile("nodes.txt")
+	nodeAddresses := discoverNodes(seeds)
 	if len(nodeAddresses) < 2 {
 		log.Println("Not enough nodes to create a fork")
 		return
@@ -76,13 +80,13 @@ func createForkAtNode(nodeAddress string) {
 	}
 
 	// 创建并挖掘一个新区块
-	newBlock := NewBlock([]*Transaction{}, latestBlock.Hash)
+	newBlock := NewBlockWithDifficulty([]*Transaction{}, latestBlock.Hash, requiredDifficulty(blockchain))
 
-	// 广播新区块
+	// 将新区块交给节点，节点验证并加入后再广播
 	var reply string
-	err = client.Call("Node.BroadcastNewBlock", newBlock, &reply)
+	err = client.Call("Node.ReceiveNewBlock", newBlock, &reply)
 	if err != nil {
-		log.Printf("Failed to broadcast new block from node %s: %v", nodeAddress, err)
+		log.Printf("Failed to add new block to node %s: %v", nodeAddress, err)
 	}
 }
 
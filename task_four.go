@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/rpc"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -14,29 +15,32 @@ func performTaskFour() {
 
 	defer cleanupChildProcesses()
 
+	seeds := localAddresses(5401, 2)
+	seedList := strings.Join(seeds, ",")
+
 	// Start the wallet application
-	go startProcess("wallet", "8080")
+	go startProcess("wallet", "8080", "-seeds", seedList)
 
 	// Start 2 nodes
 	for i := 1; i <= 2; i++ {
 		port := 5400 + i
 		time.Sleep(1 * time.Second)
-		go startProcess("node", fmt.Sprintf("%d", port))
+		go startProcess("node", fmt.Sprintf("%d", port), "-seeds", seedList)
 	}
 
-	go startProcess("consensus", "1111")
+	go startProcess("consensus", "1111", "-seeds", seedList)
 
 	// Ensure nodes are up and running
 	time.Sleep(10 * time.Second)
 
-	demonstrateInvalidPoWBlock()
+	demonstrateInvalidPoWBlock(seeds)
 
 	log.Println("Task Four Demo End.")
 
 }
 
-func demonstrateInvalidPoWBlock() {
-	nodeAddress := readKnownNodesFromFile("nodes.txt")[0]
+func demonstrateInvalidPoWBlock(seeds []string) {
+	nodeAddress := discoverNodes(seeds)[0]
 
 	client, err := rpc.Dial("tcp", nodeAddress)
 	if err != nil {
@@ -50,10 +54,10 @@ func demonstrateInvalidPoWBlock() {
 	}
 
 	// 创建一个未正确解决 PoW 的区块
-	invalidBlock := CreateInvalidPoWBlock(currentBlockchain[len(currentBlockchain)-1])
+	invalidBlock := CreateInvalidPoWBlock(currentBlockchain)
 
 	// 广播这个无效的区块到所有已知节点
-	knownNodes := readKnownNodesFromFile("nodes.txt")
+	knownNodes := discoverNodes(seeds)
 	for _, knownNode := range knownNodes {
 		client, err := rpc.Dial("tcp", knownNode)
 		if err != nil {
@@ -62,7 +66,13 @@ func demonstrateInvalidPoWBlock() {
 		}
 		var reply string
 		err = client.Call("Node.ReceiveNewBlock", invalidBlock, &reply)
-		if err != nil {
+		if validationErr := AsBlockValidationError(err); validationErr != nil {
+			fmt.Printf("Node %s rejected the block, rule %q: %s\n", knownNode, validationErr.Rule, validationErr.Reason)
+			liftBan(knownNode)
+			client.Close()
+			cleanupChildProcesses()
+			os.Exit(0)
+		} else if err != nil {
 			log.Printf("Failed to broadcast the invalid PoW block to node %s: %v", knownNode, err)
 		} else {
 			fmt.Printf("Node %s response: %s\n", knownNode, reply)
@@ -77,12 +87,41 @@ func demonstrateInvalidPoWBlock() {
 	time.Sleep(5 * time.Second)
 }
 
-func CreateInvalidPoWBlock(lastBlock *Block) *Block {
-	invalidBlock := NewBlock([]*Transaction{}, lastBlock.Hash)
+// liftBan clears the bans the invalid block earned the demo at node, which would
+// otherwise be saved in the node's data directory and outlive the demo.
+func liftBan(node string) {
+	client, err := rpc.Dial("tcp", node)
+	if err != nil {
+		log.Printf("Failed to connect to node %s: %v", node, err)
+		return
+	}
+	defer client.Close()
 
-	// Intentionally create an invalid PoW for the block
-	// you can change the block's hash without recalculating the nonce
-	invalidBlock.Hash[0] ^= 0xff
+	var reply string
+	if err := client.Call("Node.ClearBan", "", &reply); err != nil {
+		log.Printf("Failed to lift the ban at node %s: %v", node, err)
+		return
+	}
+	fmt.Printf("Node %s: %s\n", node, reply)
+}
 
-	return invalidBlock
+func CreateInvalidPoWBlock(chain []*Block) *Block {
+	lastBlock := chain[len(chain)-1]
+	invalidBlock := &Block{
+		Timestamp:     time.Now().Unix(),
+		Transactions:  []*Transaction{},
+		PrevBlockHash: lastBlock.Hash,
+		Difficulty:    requiredDifficulty(chain),
+	}
+	invalidBlock.MerkleRoot = invalidBlock.HashTransactions()
+
+	// Intentionally create an invalid PoW for the block:
+	// pick a nonce whose correctly computed hash does not meet the target
+	for {
+		invalidBlock.SetHash()
+		if invalidBlock.Validate(lastBlock) != nil {
+			return invalidBlock
+		}
+		invalidBlock.Nonce++
+	}
 }
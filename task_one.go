@@ -19,35 +19,39 @@ const NumTranscations = 100
 func performTaskOne() {
 	defer cleanupChildProcesses()
 
+	seeds := localAddresses(3101, 5)
+	seedList := strings.Join(seeds, ",")
+
 	// Start the wallet application
-	go startProcess("wallet", "8080")
+	go startProcess("wallet", "8080", "-seeds", seedList)
 
 	// Start  5 nodes
 	for i := 1; i <= 5; i++ {
 		port := 3100 + i
 		time.Sleep(1 * time.Second)
-		go startProcess("node", fmt.Sprintf("%d", port))
+		go startProcess("node", fmt.Sprintf("%d", port), "-seeds", seedList)
 	}
 
-	go startProcess("consensus", "1111")
+	go startProcess("consensus", "1111", "-seeds", seedList)
 
 	// Ensure nodes are up and running
 	time.Sleep(10 * time.Second)
 
 	// Create 100 transactions
-	createAndBroadcastTransaction()
+	createAndBroadcastTransaction(seeds)
 
 	log.Println("All transcation sent, Now wait 2 mins to complete...")
 
-	time.Sleep(20 * time.Minute)
+	// Blocks are packed full from the mempool, so a few blocks mine all 100 transactions
+	time.Sleep(2 * time.Minute)
 
-	log.Println("Completed 100 blocks")
+	log.Println("Completed 100 transactions")
 
 	cleanupChildProcesses()
 }
 
-func startProcess(mode string, port string) {
-	cmd := exec.Command("go", "run", ".", mode, port)
+func startProcess(mode string, port string, args ...string) {
+	cmd := exec.Command("go", append([]string{"run", ".", mode, port}, args...)...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
@@ -58,8 +62,8 @@ func startProcess(mode string, port string) {
 	}
 }
 
-// simulateRandomTransactions generates 100 random transactions between users.
-func simulateRandomTransactions(users []string) []*Transaction {
+// simulateRandomTransactions generates 100 random signed transactions between users.
+func simulateRandomTransactions(users []*Wallet) []*Transaction {
 	var transactions []*Transaction
 
 	if len(users) < 2 {
@@ -68,6 +72,7 @@ func simulateRandomTransactions(users []string) []*Transaction {
 	}
 
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	nonces := make(map[string]uint64) // Next nonce of each sender, starting from a fresh genesis
 
 	// Generate transactions where each user sends to the next user in the list
 	for i := 0; i < NumTranscations; i++ {
@@ -78,15 +83,21 @@ func simulateRandomTransactions(users []string) []*Transaction {
 		receiver := users[receiverIndex]
 		amount := 1 + r.Intn(2) // Random amount between 1 and 2
 
-		tx := NewTransaction(sender, receiver, amount)
+		tx, err := NewSignedTransaction(sender, receiver.Address(), amount, nonces[sender.Address()])
+		if err != nil {
+			log.Printf("Failed to sign transaction: %v", err)
+			continue
+		}
+		nonces[sender.Address()]++
 		transactions = append(transactions, tx)
 	}
 
 	return transactions
 }
 
-func readUsersFromFile(filename string) ([]string, error) {
-	var users []string
+// readUserWalletsFromFile unlocks the wallet of every user in the file with the stored password.
+func readUserWalletsFromFile(filename string) ([]*Wallet, error) {
+	var wallets []*Wallet
 
 	file, err := os.Open(filename)
 	if err != nil {
@@ -94,39 +105,60 @@ func readUsersFromFile(filename string) ([]string, error) {
 	}
 	defer file.Close()
 
+	keystore := NewKeystore(keystoreDir)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.Split(line, ":")
-		if len(parts) > 2 {
-			users = append(users, parts[2]) // Assuming the address is the third part
+		parts := strings.Split(scanner.Text(), ":")
+		if len(parts) != 3 {
+			continue
 		}
+		wallet, err := keystore.Unlock(parts[2], parts[1])
+		if err != nil {
+			log.Printf("Skipping user %s: %v", parts[0], err)
+			continue
+		}
+		wallets = append(wallets, wallet)
 	}
 
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
 
-	return users, nil
+	return wallets, nil
 }
 
 // createAndBroadcastTransaction creates and broadcasts transactions to all nodes.
-func createAndBroadcastTransaction() {
+func createAndBroadcastTransaction(seeds []string) {
 
 	// Read 5 users from users.txt
-	users, err := readUsersFromFile("users.txt")
+	users, err := readUserWalletsFromFile("users.txt")
 	if err != nil {
 		log.Fatalf("Failed to read users: %v", err)
 	}
 
+	conns := NewConnManager(NewPeerManager("", "", seeds))
+	defer conns.Close()
+	conns.Discover()
+
 	// Generate a list of transactions
 	transactions := simulateRandomTransactions(users)
 
 	// Loop through each transaction and broadcast it
 	for _, tx := range transactions {
-		BroadcastTransactionToNodes(tx)
-		time.Sleep(20 * time.Second) // Sleep for 0.5 seconds
+		BroadcastTransactionToNodes(conns, tx)
+		time.Sleep(500 * time.Millisecond) // Sleep for 0.5 seconds
+	}
+}
+
+// localAddresses returns the addresses of count nodes listening on 127.0.0.1 from port first.
+// The tasks seed every node and the wallet with all of the demo's nodes, so peers are found
+// before discovery has run.
+func localAddresses(first, count int) []string {
+	var addresses []string
+	for port := first; port < first+count; port++ {
+		addresses = append(addresses, fmt.Sprintf("127.0.0.1:%d", port))
 	}
+	return addresses
 }
 
 func cleanupChildProcesses() {
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/rpc"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -14,32 +15,35 @@ func performTaskThree() {
 
 	defer cleanupChildProcesses()
 
+	seeds := localAddresses(3501, 2)
+	seedList := strings.Join(seeds, ",")
+
 	// Start the wallet application
-	go startProcess("wallet", "8080")
+	go startProcess("wallet", "8080", "-seeds", seedList)
 
 	// Start 5 nodes
 	for i := 1; i <= 2; i++ {
 		port := 3500 + i
 		time.Sleep(1 * time.Second)
-		go startProcess("node", fmt.Sprintf("%d", port))
+		go startProcess("node", fmt.Sprintf("%d", port), "-seeds", seedList)
 	}
 
-	go startProcess("consensus", "1111")
+	go startProcess("consensus", "1111", "-seeds", seedList)
 
 	// Ensure nodes are up and running
 	time.Sleep(10 * time.Second)
 
-	createCorruptedBlock()
+	createCorruptedBlock(seeds)
 
 	log.Println("Task Three Demo End.")
 
 	cleanupChildProcesses()
 }
 
-func createCorruptedBlock() {
+func createCorruptedBlock(seeds []string) {
 
 	// Assume this is the address of a known, trustworthy node
-	nodeAddress := readKnownNodesFromFile("nodes.txt")[0]
+	nodeAddress := discoverNodes(seeds)[0]
 
 	// Connect to the node
 	client, err := rpc.Dial("tcp", nodeAddress)
@@ -65,7 +69,7 @@ func createCorruptedBlock() {
 	CorruptBlock(newBlock) // Corrupt the block
 
 	// Broadcast the corrupted block to all known nodes
-	knownNodes := readKnownNodesFromFile("nodes.txt")
+	knownNodes := discoverNodes(seeds)
 	for _, knownNode := range knownNodes {
 		client, err := rpc.Dial("tcp", knownNode)
 		if err != nil {
@@ -74,7 +78,13 @@ func createCorruptedBlock() {
 		}
 		var reply string
 		err = client.Call("Node.ReceiveNewBlock", newBlock, &reply)
-		if err != nil {
+		if validationErr := AsBlockValidationError(err); validationErr != nil {
+			fmt.Printf("Node %s rejected the block, rule %q: %s\n", knownNode, validationErr.Rule, validationErr.Reason)
+			liftBan(knownNode)
+			client.Close()
+			cleanupChildProcesses()
+			os.Exit(0)
+		} else if err != nil {
 			log.Printf("Failed to broadcast the corrupted block to node %s: %v", knownNode, err)
 		} else {
 			fmt.Printf("Node %s response: %s\n", knownNode, reply)
@@ -0,0 +1,117 @@
+package main
+
+import "math"
+
+const blockHeaderSize = 512 // Room left in MaxBlockSize for the encoded block header
+
+// BlockTemplate holds the transactions of the next block, ready to be mined.
+type BlockTemplate struct {
+	Transactions []*Transaction // Coinbase first when the template pays a miner
+	Included     int            // Number of mempool transactions in the template
+	Fees         int            // Total fees of the mempool transactions
+	Size         int            // Encoded size of the transactions in bytes
+}
+
+// NewBlockTemplate packs the mempool transactions paying the highest fee rates into the
+// next block, within MaxTransactionsPerBlock and MaxBlockSize. If miner is not empty a
+// coinbase paying the subsidy and the fees is placed first.
+//
+// Each candidate is applied to a scratch view of the UTXO set before it is taken, so a
+// template never overspends or double-spends even when several pending transactions
+// compete for the same outputs. A sender's transactions are only taken in nonce order,
+// so a block never skips a nonce. The chain's UTXO set itself is never changed. Each
+// candidate is encoded once, to learn its size.
+func (bc *Blockchain) NewBlockTemplate(miner string) *BlockTemplate {
+	height := len(bc.Blocks)
+	template := &BlockTemplate{}
+
+	maxCount := MaxTransactionsPerBlock
+	maxSize := MaxBlockSize - blockHeaderSize
+	if miner != "" {
+		maxCount--
+		// Sized with a reward far above subsidy plus fees, so the real coinbase always fits
+		maxSize -= NewCoinbaseTransaction(miner, height, math.MaxInt32).Size()
+	}
+
+	candidates := bc.Mempool.GetTransactions()
+	view := bc.UTXOSet.view(candidates)
+	undo := &blockUndo{height: view.height}
+	sizes := make(map[*Transaction]int, len(candidates))
+	for _, tx := range candidates {
+		sizes[tx] = tx.Size()
+	}
+
+	var selected []*Transaction
+	tooLarge := make(map[*Transaction]bool)
+	nonces := make(map[string]uint64)
+	for len(selected) < maxCount {
+		var next *Transaction
+		for _, tx := range candidates {
+			expected, ok := nonces[tx.From]
+			if !ok {
+				expected = bc.NextNonce(tx.From)
+			}
+			if tooLarge[tx] || tx.Nonce != expected {
+				continue
+			}
+			if template.Size+sizes[tx] > maxSize {
+				tooLarge[tx] = true // A smaller transaction may still fit
+				continue
+			}
+			// A transaction the view rejects now may become valid once another one is taken
+			if _, err := view.applyTransaction(tx, height, len(selected), undo); err != nil {
+				continue
+			}
+			next = tx
+			break
+		}
+		if next == nil {
+			break
+		}
+
+		selected = append(selected, next)
+		nonces[next.From] = next.Nonce + 1
+		template.Fees += next.Fee
+		template.Size += sizes[next]
+	}
+
+	template.Included = len(selected)
+	if miner != "" {
+		coinbase := NewCoinbaseTransaction(miner, height, BlockSubsidy(height)+template.Fees)
+		template.Size += coinbase.Size()
+		selected = append([]*Transaction{coinbase}, selected...)
+	}
+	template.Transactions = selected
+	return template
+}
+
+// checkTemplate checks that the transactions of template make a valid block on top of
+// the chain. When they do not, it returns the first mempool transaction at fault, if
+// one is: invalid on its own, not applicable after the ones before it, or paying a
+// negative fee.
+func (bc *Blockchain) checkTemplate(template *BlockTemplate) (*Transaction, error) {
+	height := len(bc.Blocks)
+	block := &Block{Transactions: template.Transactions, PrevBlockHash: bc.GetLatestBlock().Hash}
+	_, err := bc.UTXOSet.view(block.Transactions).ApplyBlock(block, height)
+	if err == nil && !block.HasValidTransactions() {
+		err = invalidBlock(RuleTransactions, "block contains an invalid transaction signature")
+	}
+	if err == nil {
+		return nil, nil
+	}
+
+	view := bc.UTXOSet.view(block.Transactions)
+	undo := &blockUndo{height: view.height}
+	for i, tx := range block.Transactions {
+		if i == 0 && tx.IsCoinbase() {
+			continue
+		}
+		if !tx.IsValid() {
+			return tx, err
+		}
+		if fee, applyErr := view.applyTransaction(tx, height, i, undo); applyErr != nil || fee < 0 {
+			return tx, err
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestBlockTemplateSkipsOverspends(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+	miner := NewWallet()
+
+	blockchain := newTestChain(t, alice.Address(), bob.Address())
+	genesis := blockchain.GetLatestBlock()
+
+	// Added behind the chain's back, the second transaction overspends alice's balance
+	first, _ := NewSignedTransactionWithFee(alice, bob.Address(), 70, 2, 0)
+	second, _ := NewSignedTransactionWithFee(alice, bob.Address(), 70, 2, 1)
+	blockchain.Mempool.AddTransaction(first)
+	blockchain.Mempool.AddTransaction(second)
+	for nonce := uint64(0); nonce < 5; nonce++ {
+		tx, _ := NewSignedTransaction(bob, alice.Address(), 1, nonce)
+		blockchain.Mempool.AddTransaction(tx)
+	}
+
+	template := blockchain.NewBlockTemplate(miner.Address())
+	if len(template.Transactions) != 7 || template.Included != 6 {
+		t.Errorf("NewBlockTemplate() failed, expected every valid transaction after the coinbase, got %d transactions", len(template.Transactions))
+	}
+	for _, tx := range template.Transactions {
+		if tx == second {
+			t.Error("NewBlockTemplate() failed, the overspending transaction was included")
+		}
+	}
+	if template.Fees != 2 || template.Transactions[0].Amount != BlockSubsidy(1)+2 {
+		t.Errorf("NewBlockTemplate() failed, expected fees of 2 paid to the miner, got %d", template.Fees)
+	}
+	if blockchain.GetBalance(alice.Address()) != 100 {
+		t.Error("NewBlockTemplate() failed, the UTXO set was not rolled back")
+	}
+
+	if err := blockchain.AddBlock(NewBlock(template.Transactions, genesis.Hash)); err != nil {
+		t.Errorf("AddBlock() failed, a block built from the template should be valid: %v", err)
+	}
+}
+
+func TestMiningEvictsTransactionsOfInvalidTemplates(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+	miner := NewWallet()
+	node := NewNode("127.0.0.1:4900", newTestChain(t, alice.Address(), bob.Address()))
+	node.MinerAddress = miner.Address()
+
+	// Added behind the chain's back, the forged transaction carries another one's signature
+	valid, _ := NewSignedTransactionWithFee(bob, alice.Address(), 10, 1, 0)
+	forged, _ := NewSignedTransactionWithFee(alice, bob.Address(), 10, 5, 0)
+	forged.Signature = valid.Signature
+	node.Blockchain.Mempool.AddTransaction(valid)
+	node.Blockchain.Mempool.AddTransaction(forged)
+
+	node.MineBlockFromMempool()
+	if len(node.Blockchain.Blocks) != 1 {
+		t.Fatalf("MineBlockFromMempool() failed, mined a block from an invalid template")
+	}
+	if node.Blockchain.Mempool.Contains(forged.ID) || !node.Blockchain.Mempool.Contains(valid.ID) {
+		t.Error("MineBlockFromMempool() failed, expected only the forged transaction to be evicted")
+	}
+}
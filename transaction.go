@@ -1,17 +1,43 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"time"
 )
 
+// MaxMoney is the largest value an amount, a fee, an output or a sum of them may have.
+// It is far above any supply the chain can reach and keeps every sum of amounts well
+// within an int.
+const MaxMoney = 1_000_000_000_000_000
+
+var ErrMoneyRange = errors.New("amount out of range")
+
+// addMoney returns a+b, or ErrMoneyRange if either of them or the sum is negative or
+// above MaxMoney.
+func addMoney(a, b int) (int, error) {
+	if a < 0 || a > MaxMoney || b < 0 || b > MaxMoney || a+b > MaxMoney {
+		return 0, ErrMoneyRange
+	}
+	return a + b, nil
+}
+
 type Transaction struct {
-	ID        []byte    // Transaction ID
-	From      string    // Sender's address
-	To        string    // Receiver's address
-	Amount    int       // Transaction amount
-	Timestamp time.Time // Transaction creation time
+	ID        []byte     // Transaction ID
+	From      string     // Sender's address
+	To        string     // Receiver's address
+	Amount    int        // Transaction amount
+	Fee       int        `json:",omitempty"` // Paid to the miner of the block that includes the transaction
+	Timestamp time.Time  // Transaction creation time
+	Nonce     uint64     // Sender's sequence number, must follow the sender's previous transaction
+	PubKey    []byte     // Sender's public key, must hash to the From address
+	Signature []byte     // ECDSA signature over the transaction ID
+	Inputs    []TxInput  `json:",omitempty"` // Outputs spent by a UTXO-model transaction
+	Outputs   []TxOutput `json:",omitempty"` // Outputs created by a UTXO-model transaction, payment first
 }
 
 // NewTransaction creates a new transaction.
@@ -21,17 +47,121 @@ func NewTransaction(from, to string, amount int) *Transaction {
 		From:      from,
 		To:        to,
 		Amount:    amount,
-		Timestamp: time.Now(), // Set the current time as the transaction creation time
+		Timestamp: time.Now().UTC(), // UTC keeps the encoded form identical on every node
 	}
 	tx.ID = tx.Hash()
 	return &tx
 }
 
+// NewSignedTransaction creates a transaction spending from the wallet's address and signs it.
+// The nonce must be the next sequence number expected for the wallet's address.
+func NewSignedTransaction(wallet *Wallet, to string, amount int, nonce uint64) (*Transaction, error) {
+	return NewSignedTransactionWithFee(wallet, to, amount, 0, nonce)
+}
+
+// NewSignedTransactionWithFee creates and signs a transaction that also pays fee to the miner.
+func NewSignedTransactionWithFee(wallet *Wallet, to string, amount, fee int, nonce uint64) (*Transaction, error) {
+	tx := NewTransaction(wallet.Address(), to, amount)
+	tx.Fee = fee
+	tx.Nonce = nonce
+	if err := tx.Sign(wallet.PrivateKey); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// NewUTXOTransaction creates a signed UTXO-model transaction that spends the wallet's
+// oldest unspent outputs, pays amount to the receiver and fee to the miner, and returns the
+// change to the sender.
+func NewUTXOTransaction(wallet *Wallet, to string, amount, fee int, nonce uint64, utxoSet *UTXOSet) (*Transaction, error) {
+	from := wallet.Address()
+
+	var inputs []TxInput
+	value := 0
+	for _, utxo := range utxoSet.SpendableUTXOs(from) {
+		if value >= amount+fee {
+			break
+		}
+		inputs = append(inputs, TxInput{TxID: utxo.TxID, Vout: utxo.Vout})
+		value += utxo.Output.Amount
+	}
+	if value < amount+fee {
+		return nil, ErrInsufficientFunds
+	}
+
+	outputs := []TxOutput{{Address: to, Amount: amount}}
+	if value > amount+fee {
+		outputs = append(outputs, TxOutput{Address: from, Amount: value - amount - fee})
+	}
+
+	tx := NewTransaction(from, to, amount)
+	tx.Fee = fee
+	tx.Nonce = nonce
+	tx.Inputs = inputs
+	tx.Outputs = outputs
+	if err := tx.Sign(wallet.PrivateKey); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// IsUTXO reports whether the transaction spends explicit inputs instead of the sender's account.
+func (tx *Transaction) IsUTXO() bool {
+	return len(tx.Inputs) > 0
+}
+
+// OutputValue returns the total value the transaction pays out, excluding account-model change and the fee.
+// It fails with ErrMoneyRange if an output or the total is negative or above MaxMoney.
+func (tx *Transaction) OutputValue() (int, error) {
+	if !tx.IsUTXO() {
+		return addMoney(tx.Amount, 0)
+	}
+	value := 0
+	for _, output := range tx.Outputs {
+		var err error
+		if value, err = addMoney(value, output.Amount); err != nil {
+			return 0, err
+		}
+	}
+	return value, nil
+}
+
+// OutputsFor returns the outputs the transaction creates when its inputs are worth inputValue.
+// Account-model transfers pay the receiver first and return what is left after the fee to the sender.
+func (tx *Transaction) OutputsFor(inputValue int) []TxOutput {
+	if tx.IsUTXO() {
+		return tx.Outputs
+	}
+	return []TxOutput{
+		{Address: tx.To, Amount: tx.Amount},
+		{Address: tx.From, Amount: inputValue - tx.Amount - tx.Fee},
+	}
+}
+
+// Size returns the length of the transaction's encoded form in bytes.
+func (tx *Transaction) Size() int {
+	encoded, err := tx.Serialize()
+	if err != nil {
+		return 0
+	}
+	return len(encoded)
+}
+
+// FeeRate returns the fee the transaction pays per 1000 bytes.
+func (tx *Transaction) FeeRate() float64 {
+	size := tx.Size()
+	if size == 0 {
+		return 0
+	}
+	return float64(tx.Fee) * 1000 / float64(size)
+}
+
 // Hash generates the hash of the transaction.
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 	txCopy := *tx
 	txCopy.ID = []byte{}
+	txCopy.Signature = nil
 
 	encoded, err := json.Marshal(txCopy)
 	if err != nil {
@@ -42,6 +172,38 @@ func (tx *Transaction) Hash() []byte {
 	return hash[:]
 }
 
+// Sign attaches the sender's public key, recomputes the ID and signs it with the private key.
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey) error {
+	tx.PubKey = pubKeyBytes(&privKey.PublicKey)
+	tx.ID = tx.Hash()
+
+	signature, err := ecdsa.SignASN1(rand.Reader, &privKey, tx.ID)
+	if err != nil {
+		return err
+	}
+	tx.Signature = signature
+	return nil
+}
+
+// Verify checks that the public key belongs to the sender and the signature covers the transaction.
+func (tx *Transaction) Verify() bool {
+	if len(tx.PubKey) == 0 || len(tx.Signature) == 0 {
+		return false
+	}
+	if AddressFromPubKey(tx.PubKey) != tx.From {
+		return false
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return false
+	}
+
+	pubKey, err := parsePubKey(tx.PubKey)
+	if err != nil {
+		return false
+	}
+	return ecdsa.VerifyASN1(pubKey, tx.ID, tx.Signature)
+}
+
 // Serialize serializes the Transaction using JSON.
 func (tx *Transaction) Serialize() ([]byte, error) {
 	return json.Marshal(tx)
@@ -57,7 +219,26 @@ func DeserializeTransaction(data []byte) (*Transaction, error) {
 	return &transaction, nil
 }
 
-// IsValid performs a simple validation: ensuring the amount is not negative and both sender and receiver are not empty.
+// IsValid checks that the amount and fee are not negative and add up to at most MaxMoney, both parties are set and the sender's signature verifies.
+// UTXO-model transactions must also pay the receiver in their first output and create no empty outputs, and their
+// outputs plus the fee must add up to at most MaxMoney.
 func (tx *Transaction) IsValid() bool {
-	return tx.Amount >= 0 && tx.From != "" && tx.To != ""
+	if tx.IsUTXO() {
+		if len(tx.Outputs) == 0 || tx.Outputs[0] != (TxOutput{Address: tx.To, Amount: tx.Amount}) {
+			return false
+		}
+		for _, output := range tx.Outputs {
+			if output.Amount <= 0 || output.Address == "" {
+				return false
+			}
+		}
+	}
+	outputValue, err := tx.OutputValue()
+	if err != nil {
+		return false
+	}
+	if _, err := addMoney(outputValue, tx.Fee); err != nil {
+		return false
+	}
+	return tx.From != "" && tx.To != "" && tx.Verify()
 }
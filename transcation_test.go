@@ -1,6 +1,7 @@
 package main
 
 import (
+	"math"
 	"reflect"
 	"testing"
 )
@@ -38,3 +39,53 @@ func TestTransactionSerializeDeserialize(t *testing.T) {
 		t.Error("Serialize() and DeserializeTransaction() failed, the transactions are not equal")
 	}
 }
+
+func TestSignedTransactionVerify(t *testing.T) {
+	wallet := NewWallet()
+	tx, err := NewSignedTransaction(wallet, "to", 10, 0)
+	if err != nil {
+		t.Fatalf("NewSignedTransaction() failed with error: %v", err)
+	}
+
+	if !tx.IsValid() {
+		t.Error("IsValid() failed, a correctly signed transaction should be valid")
+	}
+
+	tx.Amount = 1000
+	if tx.IsValid() {
+		t.Error("IsValid() failed, a tampered transaction should be invalid")
+	}
+}
+
+func TestTransactionRejectsForeignKey(t *testing.T) {
+	owner := NewWallet()
+	attacker := NewWallet()
+
+	// The attacker signs a payment that claims to come from the owner's address
+	tx := NewTransaction(owner.Address(), "to", 10)
+	if err := tx.Sign(attacker.PrivateKey); err != nil {
+		t.Fatalf("Sign() failed with error: %v", err)
+	}
+
+	if tx.IsValid() {
+		t.Error("IsValid() failed, a public key that does not hash to From should be rejected")
+	}
+
+	unsigned := NewTransaction(owner.Address(), "to", 10)
+	if unsigned.IsValid() {
+		t.Error("IsValid() failed, an unsigned transaction should be rejected")
+	}
+}
+
+func TestTransactionRejectsAmountsAboveMaxMoney(t *testing.T) {
+	wallet := NewWallet()
+	for _, c := range []struct{ amount, fee int }{{MaxMoney + 1, 0}, {0, MaxMoney + 1}, {MaxMoney, 1}, {math.MaxInt, 1}} {
+		tx, err := NewSignedTransactionWithFee(wallet, "to", c.amount, c.fee, 0)
+		if err != nil {
+			t.Fatalf("NewSignedTransactionWithFee() failed with error: %v", err)
+		}
+		if tx.IsValid() {
+			t.Errorf("IsValid() failed, amount %d with fee %d should be invalid", c.amount, c.fee)
+		}
+	}
+}
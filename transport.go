@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const identityFileName = "node.key" // Identity key persisted in a node's data directory
+
+var (
+	ErrIdentityMismatch = errors.New("peer presented another identity than before")
+	ErrPeerNotAllowed   = errors.New("peer is not on the allowlist")
+)
+
+// Identity is the long-lived key a node authenticates itself with. Peers know each
+// other by the ID derived from the public key, whatever address they connect from.
+type Identity struct {
+	key  *ecdsa.PrivateKey
+	cert tls.Certificate // Self-signed certificate for the key, presented in TLS handshakes
+}
+
+// LoadIdentity reads the identity key saved at path, creating and saving a new one
+// the first time.
+func LoadIdentity(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return createIdentity(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM key in %s", path)
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return newIdentity(key)
+}
+
+func createIdentity(path string) (*Identity, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	return newIdentity(key)
+}
+
+// newIdentity wraps key with a self-signed certificate. The certificate is only a
+// carrier for the public key: peers check the key's ID, not a chain of trust.
+func newIdentity(key *ecdsa.PrivateKey) (*Identity, error) {
+	id, err := publicKeyID(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: id},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key, cert: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}, nil
+}
+
+// ID returns the identifier of the identity, the hex SHA-256 hash of its public key.
+func (id *Identity) ID() string {
+	s, _ := publicKeyID(&id.key.PublicKey)
+	return s
+}
+
+func publicKeyID(key interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(der)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Transport opens the connections between nodes. With TLS, both ends present their
+// identity and the traffic is encrypted; a non-empty allowlist additionally refuses
+// every peer whose ID is not on it, in both directions. A nil Transport, the default,
+// speaks plain TCP.
+type Transport struct {
+	Identity *Identity
+	TLS      bool
+	Allowed  map[string]bool // IDs of the peers accepted, any peer when empty
+}
+
+// NewTransport creates a transport for identity. The allowlist requires TLS, so TLS is
+// enabled whenever allowed IDs are given.
+func NewTransport(identity *Identity, useTLS bool, allowed []string) *Transport {
+	t := &Transport{Identity: identity, TLS: useTLS || len(allowed) > 0}
+	if len(allowed) > 0 {
+		t.Allowed = make(map[string]bool, len(allowed))
+		for _, id := range allowed {
+			t.Allowed[id] = true
+		}
+	}
+	return t
+}
+
+// verify accepts the certificate of a peer if its key is allowed.
+func (t *Transport) verify(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("peer presented no certificate")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	id, err := publicKeyID(cert.PublicKey)
+	if err != nil {
+		return err
+	}
+	if len(t.Allowed) > 0 && !t.Allowed[id] {
+		return fmt.Errorf("%w: %s", ErrPeerNotAllowed, id)
+	}
+	return nil
+}
+
+func (t *Transport) config() *tls.Config {
+	return &tls.Config{
+		Certificates:          []tls.Certificate{t.Identity.cert},
+		ClientAuth:            tls.RequireAnyClientCert,
+		InsecureSkipVerify:    true, // Self-signed certificates, checked by verify instead
+		VerifyPeerCertificate: t.verify,
+		MinVersion:            tls.VersionTLS13,
+	}
+}
+
+// Listen listens for peers on address.
+func (t *Transport) Listen(address string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil || t == nil || !t.TLS {
+		return listener, err
+	}
+	return tls.NewListener(listener, t.config()), nil
+}
+
+// Dial connects to a peer within dialTimeout and returns the connection with the ID of
+// the peer, empty over plain TCP.
+func (t *Transport) Dial(address string) (net.Conn, string, error) {
+	if t == nil || !t.TLS {
+		conn, err := net.DialTimeout("tcp", address, dialTimeout)
+		return conn, "", err
+	}
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: dialTimeout}, Config: t.config()}
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := remoteIdentity(conn)
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	return conn, id, nil
+}
+
+// remoteIdentity completes the TLS handshake of conn within dialTimeout and returns the
+// ID of the peer. Plain TCP connections have no identity.
+func remoteIdentity(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	tlsConn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return "", err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", errors.New("peer presented no certificate")
+	}
+	return publicKeyID(certs[0].PublicKey)
+}
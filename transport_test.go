@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net/rpc"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadIdentityPersistsKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), identityFileName)
+	identity, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatalf("LoadIdentity() failed with error: %v", err)
+	}
+	reloaded, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatalf("LoadIdentity() failed with error: %v", err)
+	}
+	if identity.ID() != reloaded.ID() {
+		t.Errorf("LoadIdentity() failed, expected %s after reloading, got %s", identity.ID(), reloaded.ID())
+	}
+}
+
+// startTLSNode serves the RPCs of a node over transport on a free local port.
+func startTLSNode(t *testing.T, blockchain *Blockchain, transport *Transport) *Node {
+	listener, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed with error: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	node := NewNode(listener.Addr().String(), blockchain)
+	node.Transport = transport
+	node.UsePeers(node.Peers)
+	server := rpc.NewServer()
+	server.Register(node)
+	go node.Serve(listener, server)
+	return node
+}
+
+func newTestIdentity(t *testing.T) *Identity {
+	identity, err := LoadIdentity(filepath.Join(t.TempDir(), identityFileName))
+	if err != nil {
+		t.Fatalf("LoadIdentity() failed with error: %v", err)
+	}
+	return identity
+}
+
+func TestTLSTransportPinsIdentity(t *testing.T) {
+	chain := newTestChain(t)
+	remoteIdentity := newTestIdentity(t)
+	remote := startTLSNode(t, chain, NewTransport(remoteIdentity, true, nil))
+
+	local := NewNode("127.0.0.1:4600", chain)
+	local.Transport = NewTransport(newTestIdentity(t), true, nil)
+	local.UsePeers(local.Peers)
+	local.Peers.Add(remote.Address)
+	defer local.Conns.Close()
+
+	var blocks []*Block
+	if err := local.Conns.Call(remote.Address, "Node.GetCurrentBlockchain", "test", &blocks, CapabilityBlocks); err != nil {
+		t.Fatalf("Call() failed with error: %v", err)
+	}
+	if id := local.Peers.Identity(remote.Address); id != remoteIdentity.ID() {
+		t.Errorf("Identity() failed, expected %s, got %s", remoteIdentity.ID(), id)
+	}
+
+	// Another key on the same address is an impostor
+	if err := local.Peers.pin(remote.Address, newTestIdentity(t).ID()); !errors.Is(err, ErrIdentityMismatch) {
+		t.Errorf("pin() failed, expected ErrIdentityMismatch, got %v", err)
+	}
+}
+
+func TestTLSTransportAllowlist(t *testing.T) {
+	chain := newTestChain(t)
+	member := newTestIdentity(t)
+	remote := startTLSNode(t, chain, NewTransport(newTestIdentity(t), false, []string{member.ID()}))
+
+	outsider := NewConnManager(NewPeerManager("", "", []string{remote.Address}))
+	outsider.Peers.Transport = NewTransport(newTestIdentity(t), true, nil)
+	defer outsider.Close()
+	var blocks []*Block
+	if err := outsider.Call(remote.Address, "Node.GetCurrentBlockchain", "test", &blocks); err == nil {
+		t.Error("Call() failed, expected a peer off the allowlist to be refused")
+	}
+
+	allowed := NewConnManager(NewPeerManager("", "", []string{remote.Address}))
+	allowed.Peers.Transport = NewTransport(member, true, nil)
+	defer allowed.Close()
+	if err := allowed.Call(remote.Address, "Node.GetCurrentBlockchain", "test", &blocks); err != nil {
+		t.Errorf("Call() failed with error: %v", err)
+	}
+}
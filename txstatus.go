@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// States of a transaction sent from the wallet.
+const (
+	TxPending   = "pending"    // Broadcast, not yet seen in any node's mempool
+	TxInMempool = "in-mempool" // Waiting in the mempool of at least one node
+	TxIncluded  = "included"   // In a block of the chain
+	TxDropped   = "dropped"    // Gone from every mempool without being mined, or never accepted
+	TxReplaced  = "replaced"   // Another transaction with the same nonce or inputs was mined instead
+)
+
+var (
+	TxPendingTimeout   = 2 * time.Minute // How long a transaction no node has accepted stays pending before it counts as dropped
+	TxFinalDepth       = 6               // Confirmations after which an included transaction is final
+	TrackedTxRetention = 24 * time.Hour  // How long final transactions, and dropped ones since their submission, are kept
+)
+
+// TxStatus is what is known about a transaction: whether it was mined, and if not,
+// which nodes hold it in their mempool.
+type TxStatus struct {
+	ID            string // Hex-encoded transaction ID
+	From          string
+	To            string
+	Amount        int
+	Fee           int
+	State         string
+	Submitted     time.Time
+	Updated       time.Time
+	MempoolNodes  []string `json:",omitempty"` // Nodes whose mempool held the transaction at the last check
+	Height        int      `json:",omitempty"` // Of the block including the transaction
+	BlockHash     string   `json:",omitempty"`
+	Confirmations int      `json:",omitempty"` // Blocks from the including block to the tip, itself included
+	ReplacedBy    string   `json:",omitempty"` // ID of the conflicting transaction that was mined
+}
+
+// Description returns the status in words, for the wallet pages.
+func (s TxStatus) Description() string {
+	switch s.State {
+	case TxInMempool:
+		return fmt.Sprintf("In the mempool of %d nodes", len(s.MempoolNodes))
+	case TxIncluded:
+		return fmt.Sprintf("Included at height %d, %d confirmations", s.Height, s.Confirmations)
+	case TxDropped:
+		return "Dropped"
+	case TxReplaced:
+		return "Replaced by " + s.ReplacedBy
+	}
+	return "Pending"
+}
+
+// final reports whether the status can no longer change in practice. Dropped
+// transactions are not final: a node may still mine one it kept.
+func (s TxStatus) final() bool {
+	return s.State == TxReplaced || s.State == TxIncluded && s.Confirmations >= TxFinalDepth
+}
+
+type trackedTx struct {
+	tx     *Transaction
+	seen   bool // Was in a mempool or a block at some check
+	status TxStatus
+}
+
+// TxTracker follows the transactions sent from the wallet until they are mined or lost.
+// Their status is recomputed at every Update from the chain and the nodes' mempools,
+// so a transaction dropped by a reorg or mined after all is reported as such.
+type TxTracker struct {
+	mutex sync.Mutex
+	txs   map[string]*trackedTx // By hex-encoded ID
+}
+
+// NewTxTracker creates a tracker following no transaction.
+func NewTxTracker() *TxTracker {
+	return &TxTracker{txs: make(map[string]*trackedTx)}
+}
+
+// Track starts following a transaction that was just broadcast.
+func (t *TxTracker) Track(tx *Transaction) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	id := hex.EncodeToString(tx.ID)
+	if t.txs[id] != nil {
+		return
+	}
+	now := time.Now()
+	t.txs[id] = &trackedTx{tx: tx, status: TxStatus{
+		ID:        id,
+		From:      tx.From,
+		To:        tx.To,
+		Amount:    tx.Amount,
+		Fee:       tx.Fee,
+		State:     TxPending,
+		Submitted: now,
+		Updated:   now,
+	}}
+}
+
+// Unconfirmed returns the transactions from address still waiting to be mined.
+func (t *TxTracker) Unconfirmed(address string) []*Transaction {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var txs []*Transaction
+	for _, tracked := range t.txs {
+		state := tracked.status.State
+		if tracked.tx.From == address && (state == TxPending || state == TxInMempool) {
+			txs = append(txs, tracked.tx)
+		}
+	}
+	return txs
+}
+
+// Open returns the transactions whose status may still change, the ones worth asking
+// the nodes about.
+func (t *TxTracker) Open() []*Transaction {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var txs []*Transaction
+	for _, tracked := range t.txs {
+		if !tracked.status.final() {
+			txs = append(txs, tracked.tx)
+		}
+	}
+	return txs
+}
+
+// Status returns the status of a tracked transaction.
+func (t *TxTracker) Status(id string) (TxStatus, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	tracked := t.txs[id]
+	if tracked == nil {
+		return TxStatus{}, false
+	}
+	return tracked.status, true
+}
+
+// Statuses returns the status of the tracked transactions from or to address, the most
+// recently sent first.
+func (t *TxTracker) Statuses(address string) []TxStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var statuses []TxStatus
+	for _, tracked := range t.txs {
+		if tracked.tx.From == address || tracked.tx.To == address {
+			statuses = append(statuses, tracked.status)
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Submitted.After(statuses[j].Submitted) })
+	return statuses
+}
+
+// Update recomputes the status of every open transaction from chain and from mempools,
+// which maps the hex-encoded IDs of transactions to the nodes holding them in their
+// mempool. Transactions final, or dropped, for longer than TrackedTxRetention are forgotten.
+func (t *TxTracker) Update(chain []*Block, mempools map[string][]string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	for id, tracked := range t.txs {
+		status := tracked.status
+		if status.final() && now.Sub(status.Updated) > TrackedTxRetention ||
+			status.State == TxDropped && now.Sub(status.Submitted) > TrackedTxRetention {
+			delete(t.txs, id)
+			continue
+		}
+		if status.final() {
+			continue
+		}
+
+		status.MempoolNodes = mempools[id]
+		status.Height, status.BlockHash, status.Confirmations, status.ReplacedBy = 0, "", 0, ""
+		if height, block, _ := findTransaction(chain, tracked.tx.ID); block != nil {
+			status.State = TxIncluded
+			status.Height = height
+			status.BlockHash = hex.EncodeToString(block.Hash)
+			status.Confirmations = len(chain) - height
+			status.MempoolNodes = nil
+			tracked.seen = true
+		} else if conflict := findConflict(chain, tracked.tx); conflict != nil {
+			status.State = TxReplaced
+			status.ReplacedBy = hex.EncodeToString(conflict.ID)
+		} else if len(status.MempoolNodes) > 0 {
+			status.State = TxInMempool
+			tracked.seen = true
+		} else if tracked.seen || now.Sub(status.Submitted) > TxPendingTimeout {
+			status.State = TxDropped
+		} else {
+			status.State = TxPending
+		}
+		status.Updated = now
+		tracked.status = status
+	}
+}
+
+// findTransaction returns the height and the block of chain including a transaction,
+// and the transaction.
+func findTransaction(chain []*Block, txID []byte) (int, *Block, *Transaction) {
+	for height := len(chain) - 1; height >= 0; height-- {
+		for _, tx := range chain[height].Transactions {
+			if bytes.Equal(tx.ID, txID) {
+				return height, chain[height], tx
+			}
+		}
+	}
+	return 0, nil, nil
+}
+
+// findConflict returns a transaction of chain that makes tx invalid: one from the same
+// sender with the same nonce, or one spending an output tx spends.
+func findConflict(chain []*Block, tx *Transaction) *Transaction {
+	spends := make(map[string]bool, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		spends[outpoint(input.TxID, input.Vout)] = true
+	}
+	for _, block := range chain {
+		for _, other := range block.Transactions {
+			if bytes.Equal(other.ID, tx.ID) {
+				continue
+			}
+			if tx.From != "" && other.From == tx.From && other.Nonce == tx.Nonce {
+				return other
+			}
+			for _, input := range other.Inputs {
+				if spends[outpoint(input.TxID, input.Vout)] {
+					return other
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// chainTxStatus returns the status of a transaction the tracker does not follow, from
+// the chain alone.
+func chainTxStatus(chain []*Block, id string) (TxStatus, bool) {
+	txID, err := hex.DecodeString(id)
+	if err != nil {
+		return TxStatus{}, false
+	}
+	height, block, tx := findTransaction(chain, txID)
+	if block == nil {
+		return TxStatus{}, false
+	}
+	return includedStatus(chain, height, tx), true
+}
+
+// includedStatus returns the status of tx, included in the block of chain at height.
+func includedStatus(chain []*Block, height int, tx *Transaction) TxStatus {
+	return TxStatus{
+		ID:            hex.EncodeToString(tx.ID),
+		From:          tx.From,
+		To:            tx.To,
+		Amount:        tx.Amount,
+		Fee:           tx.Fee,
+		State:         TxIncluded,
+		Submitted:     tx.Timestamp,
+		Updated:       time.Now(),
+		Height:        height,
+		BlockHash:     hex.EncodeToString(chain[height].Hash),
+		Confirmations: len(chain) - height,
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTxTrackerFollowsTransactionToConfirmation(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+	genesis := NewBlock([]*Transaction{NewTransaction("", alice.Address(), 100)}, []byte{})
+	tx, _ := NewSignedTransaction(alice, bob.Address(), 10, 0)
+	id := hex.EncodeToString(tx.ID)
+
+	tracker := NewTxTracker()
+	tracker.Track(tx)
+	if status, _ := tracker.Status(id); status.State != TxPending {
+		t.Errorf("Track() failed, expected pending, got %s", status.State)
+	}
+	if unconfirmed := tracker.Unconfirmed(alice.Address()); len(unconfirmed) != 1 {
+		t.Errorf("Unconfirmed() failed, expected the transaction, got %v", unconfirmed)
+	}
+
+	tracker.Update([]*Block{genesis}, map[string][]string{id: {"127.0.0.1:3000", "127.0.0.1:3001"}})
+	if status, _ := tracker.Status(id); status.State != TxInMempool || len(status.MempoolNodes) != 2 {
+		t.Errorf("Update() failed, expected in the mempool of 2 nodes, got %+v", status)
+	}
+
+	block := NewBlock([]*Transaction{tx}, genesis.Hash)
+	chain := extendChain([]*Block{genesis, block}, 2)
+	tracker.Update(chain, nil)
+	status, _ := tracker.Status(id)
+	if status.State != TxIncluded || status.Height != 1 || status.Confirmations != 3 {
+		t.Errorf("Update() failed, expected included at height 1 with 3 confirmations, got %+v", status)
+	}
+	if unconfirmed := tracker.Unconfirmed(alice.Address()); len(unconfirmed) != 0 {
+		t.Errorf("Unconfirmed() failed, expected nothing once mined, got %v", unconfirmed)
+	}
+
+	// A reorg dropping the block takes the transaction back out of the chain
+	tracker.Update([]*Block{genesis}, nil)
+	if status, _ := tracker.Status(id); status.State != TxDropped {
+		t.Errorf("Update() failed, expected dropped after a reorg, got %s", status.State)
+	}
+}
+
+func TestTxTrackerDetectsReplacement(t *testing.T) {
+	defer func(timeout time.Duration) { TxPendingTimeout = timeout }(TxPendingTimeout)
+	TxPendingTimeout = time.Hour
+
+	alice := NewWallet()
+	genesis := NewBlock([]*Transaction{NewTransaction("", alice.Address(), 100)}, []byte{})
+	original, _ := NewSignedTransactionWithFee(alice, NewWallet().Address(), 10, 0, 0)
+	replacement, _ := NewSignedTransactionWithFee(alice, NewWallet().Address(), 10, 5, 0)
+
+	tracker := NewTxTracker()
+	tracker.Track(original)
+	tracker.Update([]*Block{genesis, NewBlock([]*Transaction{replacement}, genesis.Hash)}, nil)
+
+	status, _ := tracker.Status(hex.EncodeToString(original.ID))
+	if status.State != TxReplaced || status.ReplacedBy != hex.EncodeToString(replacement.ID) {
+		t.Errorf("Update() failed, expected replaced by %x, got %+v", replacement.ID, status)
+	}
+}
+
+func TestTxStatusEndpoint(t *testing.T) {
+	alice := NewWallet()
+	genesis := NewBlock([]*Transaction{NewTransaction("", alice.Address(), 100)}, []byte{})
+	app := &Application{Blockchain: newChainFrom(t, []*Block{genesis}), Tracker: NewTxTracker()}
+
+	tx, _ := NewSignedTransaction(alice, NewWallet().Address(), 10, 0)
+	app.Tracker.Track(tx)
+
+	recorder := httptest.NewRecorder()
+	app.handleTxStatus(recorder, httptest.NewRequest("GET", "/tx/"+hex.EncodeToString(tx.ID)+"?format=json", nil))
+	var status TxStatus
+	if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil || status.State != TxPending {
+		t.Errorf("handleTxStatus() failed, expected a pending transaction, got %+v with error %v", status, err)
+	}
+
+	// Transactions not sent from this wallet are looked up in the chain
+	recorder = httptest.NewRecorder()
+	app.handleTxStatus(recorder, httptest.NewRequest("GET", "/tx/"+hex.EncodeToString(genesis.Transactions[0].ID), nil))
+	if recorder.Code != 200 {
+		t.Errorf("handleTxStatus() failed, expected the genesis transaction page, got status %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	app.handleTxStatus(recorder, httptest.NewRequest("GET", "/tx/00ff", nil))
+	if recorder.Code != 404 {
+		t.Errorf("handleTxStatus() failed, expected 404 for an unknown transaction, got %d", recorder.Code)
+	}
+}
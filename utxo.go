@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// TxInput references an output of a previous transaction that is being spent.
+type TxInput struct {
+	TxID []byte // ID of the transaction that created the output
+	Vout int    // Index of the output in that transaction
+}
+
+// TxOutput locks an amount to an address.
+type TxOutput struct {
+	Address string // Owner of the output
+	Amount  int    // Value of the output
+}
+
+// UTXO is an unspent transaction output together with where it was created.
+type UTXO struct {
+	TxID     []byte
+	Vout     int
+	Height   int  // Height of the block that created the output
+	TxIndex  int  // Position of the creating transaction in its block
+	Coinbase bool // Created by a coinbase, spendable only after CoinbaseMaturity blocks
+	Output   TxOutput
+}
+
+// Spendable reports whether the output may be spent by a block at height.
+func (utxo *UTXO) Spendable(height int) bool {
+	return !utxo.Coinbase || height-utxo.Height >= CoinbaseMaturity
+}
+
+// outpoint returns the key identifying an output.
+func outpoint(txID []byte, vout int) string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(txID), vout)
+}
+
+// UTXOSet holds every unspent output of the chain and is updated block by block,
+// so balance and double-spend checks never need to rescan the blockchain.
+//
+// Transactions with explicit Inputs spend exactly those outputs. Account-model
+// transactions spend the sender's oldest outputs until the amount is covered and
+// return the change to the sender, so both models share the same state.
+type UTXOSet struct {
+	utxos     map[string]*UTXO           // Unspent outputs by outpoint
+	byAddress map[string]map[string]bool // Outpoints owned by each address
+	height    int                        // Height of the next block to be applied
+}
+
+// blockUndo records what applying a block changed, so it can be rolled back.
+type blockUndo struct {
+	spent   []*UTXO  // Outputs the block consumed
+	created []string // Outpoints the block created
+	height  int      // Height of the next block before the block was applied
+}
+
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// NewUTXOSet creates an empty UTXO set.
+func NewUTXOSet() *UTXOSet {
+	return &UTXOSet{
+		utxos:     make(map[string]*UTXO),
+		byAddress: make(map[string]map[string]bool),
+	}
+}
+
+// Balance returns the total value of the outputs owned by an address.
+func (u *UTXOSet) Balance(address string) int {
+	balance := 0
+	for key := range u.byAddress[address] {
+		balance += u.utxos[key].Output.Amount
+	}
+	return balance
+}
+
+// FindUTXOs returns the unspent outputs of an address, oldest first.
+func (u *UTXOSet) FindUTXOs(address string) []*UTXO {
+	var utxos []*UTXO
+	for key := range u.byAddress[address] {
+		utxos = append(utxos, u.utxos[key])
+	}
+
+	sort.Slice(utxos, func(i, j int) bool {
+		a, b := utxos[i], utxos[j]
+		if a.Height != b.Height {
+			return a.Height < b.Height
+		}
+		if a.TxIndex != b.TxIndex {
+			return a.TxIndex < b.TxIndex
+		}
+		return a.Vout < b.Vout
+	})
+	return utxos
+}
+
+// SpendableUTXOs returns the outputs of an address the next block may spend, oldest first.
+func (u *UTXOSet) SpendableUTXOs(address string) []*UTXO {
+	var spendable []*UTXO
+	for _, utxo := range u.FindUTXOs(address) {
+		if utxo.Spendable(u.height) {
+			spendable = append(spendable, utxo)
+		}
+	}
+	return spendable
+}
+
+// Get returns the unspent output at an outpoint, if any.
+func (u *UTXOSet) Get(txID []byte, vout int) (*UTXO, bool) {
+	utxo, ok := u.utxos[outpoint(txID, vout)]
+	return utxo, ok
+}
+
+// CheckTransaction reports whether the transaction could be applied on top of the current set.
+func (u *UTXOSet) CheckTransaction(tx *Transaction) error {
+	_, err := u.selectInputs(tx, u.height)
+	return err
+}
+
+// CheckTransactions reports whether the transactions could all be applied, in order,
+// on top of the current set. They are applied to a scratch view, so the set is never
+// changed, not even for a moment.
+func (u *UTXOSet) CheckTransactions(txs []*Transaction) error {
+	view := u.view(txs)
+	undo := &blockUndo{height: u.height}
+	for i, tx := range txs {
+		if _, err := view.applyTransaction(tx, u.height, i, undo); err != nil {
+			return fmt.Errorf("transaction %x: %v", tx.ID, err)
+		}
+	}
+	return nil
+}
+
+// view returns a set holding the outputs the transactions may spend: those of their
+// senders and those named by their inputs.
+func (u *UTXOSet) view(txs []*Transaction) *UTXOSet {
+	view := NewUTXOSet()
+	view.height = u.height
+	for _, tx := range txs {
+		for key := range u.byAddress[tx.From] {
+			view.add(u.utxos[key])
+		}
+		for _, input := range tx.Inputs {
+			if utxo, ok := u.utxos[outpoint(input.TxID, input.Vout)]; ok {
+				view.add(utxo)
+			}
+		}
+	}
+	return view
+}
+
+// ApplyBlock spends and creates the outputs of every transaction in the block.
+// Apart from the genesis block, only the first transaction may be a coinbase and it may
+// pay at most the block subsidy plus the fees of the other transactions.
+// If any rule is broken the set is left unchanged.
+func (u *UTXOSet) ApplyBlock(block *Block, height int) (*blockUndo, error) {
+	undo := &blockUndo{height: u.height}
+	fees := 0
+	for i, tx := range block.Transactions {
+		if height > 0 && i > 0 && tx.IsCoinbase() {
+			u.Rollback(undo)
+			return nil, fmt.Errorf("transaction %x: coinbase must be the first transaction", tx.ID)
+		}
+		fee, err := u.applyTransaction(tx, height, i, undo)
+		if err == nil {
+			fees, err = addMoney(fees, fee)
+		}
+		if err != nil {
+			u.Rollback(undo)
+			return nil, fmt.Errorf("transaction %x: %v", tx.ID, err)
+		}
+	}
+
+	if height > 0 && len(block.Transactions) > 0 && block.Transactions[0].IsCoinbase() {
+		reward, err := block.Transactions[0].OutputValue()
+		if err != nil {
+			u.Rollback(undo)
+			return nil, fmt.Errorf("coinbase: %v", err)
+		}
+		if allowed := BlockSubsidy(height) + fees; reward > allowed {
+			u.Rollback(undo)
+			return nil, fmt.Errorf("coinbase pays %d, allowed at most %d", reward, allowed)
+		}
+	}
+	u.height = height + 1
+	return undo, nil
+}
+
+// Rollback reverts the changes recorded in undo.
+func (u *UTXOSet) Rollback(undo *blockUndo) {
+	for i := len(undo.created) - 1; i >= 0; i-- {
+		u.remove(undo.created[i])
+	}
+	for i := len(undo.spent) - 1; i >= 0; i-- {
+		u.add(undo.spent[i])
+	}
+	u.height = undo.height
+}
+
+// applyTransaction spends the transaction's inputs, adds its outputs and returns its fee.
+func (u *UTXOSet) applyTransaction(tx *Transaction, height, txIndex int, undo *blockUndo) (int, error) {
+	var spent []*UTXO
+	var err error
+	if !tx.IsCoinbase() {
+		spent, err = u.selectInputs(tx, height)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	inputValue := 0
+	for _, utxo := range spent {
+		if inputValue, err = addMoney(inputValue, utxo.Output.Amount); err != nil {
+			return 0, err
+		}
+	}
+	outputs := tx.OutputsFor(inputValue)
+	outputValue := 0
+	for _, output := range outputs {
+		if output.Amount <= 0 {
+			continue
+		}
+		if outputValue, err = addMoney(outputValue, output.Amount); err != nil {
+			return 0, err
+		}
+	}
+	fee := inputValue - outputValue
+	if !tx.IsCoinbase() && fee < 0 {
+		return 0, fmt.Errorf("outputs worth %d exceed inputs worth %d", outputValue, inputValue)
+	}
+
+	for _, utxo := range spent {
+		u.remove(outpoint(utxo.TxID, utxo.Vout))
+		undo.spent = append(undo.spent, utxo)
+	}
+	for vout, output := range outputs {
+		if output.Amount <= 0 {
+			continue
+		}
+		utxo := &UTXO{TxID: tx.ID, Vout: vout, Height: height, TxIndex: txIndex, Coinbase: tx.IsCoinbase() && height > 0, Output: output}
+		u.add(utxo)
+		undo.created = append(undo.created, outpoint(tx.ID, vout))
+	}
+
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+	return fee, nil
+}
+
+// selectInputs returns the outputs a transaction spends in a block at height, checking
+// they exist, are mature and cover its outputs and fee.
+func (u *UTXOSet) selectInputs(tx *Transaction, height int) ([]*UTXO, error) {
+	var selected []*UTXO
+	value := 0
+
+	if tx.IsUTXO() {
+		outputValue, err := tx.OutputValue()
+		if err != nil {
+			return nil, err
+		}
+		required, err := addMoney(outputValue, tx.Fee)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		for _, input := range tx.Inputs {
+			key := outpoint(input.TxID, input.Vout)
+			utxo, ok := u.utxos[key]
+			if !ok || seen[key] {
+				return nil, fmt.Errorf("input %s is unknown or already spent", key)
+			}
+			if utxo.Output.Address != tx.From {
+				return nil, fmt.Errorf("input %s is not owned by %s", key, tx.From)
+			}
+			if !utxo.Spendable(height) {
+				return nil, fmt.Errorf("input %s is an immature coinbase output", key)
+			}
+			seen[key] = true
+			selected = append(selected, utxo)
+			if value, err = addMoney(value, utxo.Output.Amount); err != nil {
+				return nil, err
+			}
+		}
+
+		if value < required {
+			return nil, ErrInsufficientFunds
+		}
+		if value > required {
+			return nil, fmt.Errorf("inputs worth %d exceed outputs plus fee %d", value, required)
+		}
+		return selected, nil
+	}
+
+	// Account-model transfer: spend the sender's oldest outputs first
+	required, err := addMoney(tx.Amount, tx.Fee)
+	if err != nil {
+		return nil, err
+	}
+	for _, utxo := range u.FindUTXOs(tx.From) {
+		if value >= required {
+			break
+		}
+		if !utxo.Spendable(height) {
+			continue
+		}
+		selected = append(selected, utxo)
+		value += utxo.Output.Amount
+	}
+	if value < required {
+		return nil, ErrInsufficientFunds
+	}
+	return selected, nil
+}
+
+func (u *UTXOSet) add(utxo *UTXO) {
+	key := outpoint(utxo.TxID, utxo.Vout)
+	u.utxos[key] = utxo
+
+	owned, ok := u.byAddress[utxo.Output.Address]
+	if !ok {
+		owned = make(map[string]bool)
+		u.byAddress[utxo.Output.Address] = owned
+	}
+	owned[key] = true
+}
+
+func (u *UTXOSet) remove(key string) {
+	utxo, ok := u.utxos[key]
+	if !ok {
+		return
+	}
+	delete(u.utxos, key)
+
+	owned := u.byAddress[utxo.Output.Address]
+	delete(owned, key)
+	if len(owned) == 0 {
+		delete(u.byAddress, utxo.Output.Address)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUTXOSetAccountTransfer(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+
+	utxoSet := NewUTXOSet()
+	genesis := &Block{Transactions: []*Transaction{NewTransaction("", alice.Address(), 100)}}
+	if _, err := utxoSet.ApplyBlock(genesis, 0); err != nil {
+		t.Fatalf("ApplyBlock() failed with error: %v", err)
+	}
+
+	tx, _ := NewSignedTransaction(alice, bob.Address(), 30, 0)
+	if _, err := utxoSet.ApplyBlock(&Block{Transactions: []*Transaction{tx}}, 1); err != nil {
+		t.Fatalf("ApplyBlock() failed with error: %v", err)
+	}
+
+	if utxoSet.Balance(alice.Address()) != 70 || utxoSet.Balance(bob.Address()) != 30 {
+		t.Errorf("ApplyBlock() failed, expected balances 70/30, got %d/%d",
+			utxoSet.Balance(alice.Address()), utxoSet.Balance(bob.Address()))
+	}
+}
+
+func TestUTXOSetRejectsDoubleSpendInBlock(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+
+	utxoSet := NewUTXOSet()
+	genesis := &Block{Transactions: []*Transaction{NewTransaction("", alice.Address(), 100)}}
+	utxoSet.ApplyBlock(genesis, 0)
+
+	// Both transactions spend the same genesis output
+	tx1, err := NewUTXOTransaction(alice, bob.Address(), 60, 0, 0, utxoSet)
+	if err != nil {
+		t.Fatalf("NewUTXOTransaction() failed with error: %v", err)
+	}
+	tx2, _ := NewUTXOTransaction(alice, bob.Address(), 50, 0, 1, utxoSet)
+	if !tx1.IsValid() || !tx2.IsValid() {
+		t.Fatal("NewUTXOTransaction() failed, the transactions should be validly signed")
+	}
+
+	if _, err := utxoSet.ApplyBlock(&Block{Transactions: []*Transaction{tx1, tx2}}, 1); err == nil {
+		t.Error("ApplyBlock() failed, a block spending the same output twice should be rejected")
+	}
+	if utxoSet.Balance(alice.Address()) != 100 || utxoSet.Balance(bob.Address()) != 0 {
+		t.Error("ApplyBlock() failed, a rejected block should leave the set unchanged")
+	}
+
+	if _, err := utxoSet.ApplyBlock(&Block{Transactions: []*Transaction{tx1}}, 1); err != nil {
+		t.Fatalf("ApplyBlock() failed with error: %v", err)
+	}
+	if utxoSet.Balance(alice.Address()) != 40 || utxoSet.Balance(bob.Address()) != 60 {
+		t.Error("ApplyBlock() failed, the change output should return to the sender")
+	}
+	if err := utxoSet.CheckTransaction(tx2); err == nil {
+		t.Error("CheckTransaction() failed, spending an already spent output should be rejected")
+	}
+}
+
+func TestUTXOSetCheckTransactionsLeavesSetUnchanged(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+	utxoSet := NewUTXOSet()
+	if _, err := utxoSet.ApplyBlock(NewBlock([]*Transaction{NewTransaction("", alice.Address(), 100)}, []byte{}), 0); err != nil {
+		t.Fatalf("ApplyBlock() failed with error: %v", err)
+	}
+
+	first, _ := NewSignedTransaction(alice, bob.Address(), 60, 0)
+	second, _ := NewSignedTransaction(alice, bob.Address(), 30, 1)
+	if err := utxoSet.CheckTransactions([]*Transaction{first, second}); err != nil {
+		t.Errorf("CheckTransactions() failed with error: %v", err)
+	}
+	third, _ := NewSignedTransaction(alice, bob.Address(), 20, 2)
+	if err := utxoSet.CheckTransactions([]*Transaction{first, second, third}); err == nil {
+		t.Error("CheckTransactions() failed, expected the combined overspend to be rejected")
+	}
+	if utxoSet.Balance(alice.Address()) != 100 || utxoSet.Balance(bob.Address()) != 0 {
+		t.Errorf("CheckTransactions() failed, the set changed: alice has %d, bob has %d",
+			utxoSet.Balance(alice.Address()), utxoSet.Balance(bob.Address()))
+	}
+}
+
+func TestUTXOSetRejectsOverflowingTransfer(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+	utxoSet := NewUTXOSet()
+	if _, err := utxoSet.ApplyBlock(&Block{Transactions: []*Transaction{NewTransaction("", alice.Address(), 100)}}, 0); err != nil {
+		t.Fatalf("ApplyBlock() failed with error: %v", err)
+	}
+
+	// Amount plus fee wraps around to a negative total the balance would cover
+	tx, _ := NewSignedTransactionWithFee(alice, bob.Address(), math.MaxInt, 1, 0)
+	if _, err := utxoSet.ApplyBlock(&Block{Transactions: []*Transaction{tx}}, 1); err == nil {
+		t.Error("ApplyBlock() failed, expected the overflowing transfer to be rejected")
+	}
+	if utxoSet.Balance(alice.Address()) != 100 || utxoSet.Balance(bob.Address()) != 0 {
+		t.Errorf("ApplyBlock() failed, the set changed: alice has %d, bob has %d",
+			utxoSet.Balance(alice.Address()), utxoSet.Balance(bob.Address()))
+	}
+}
+
+func TestUTXOSetRejectsOverflowingOutputs(t *testing.T) {
+	alice := NewWallet()
+	bob := NewWallet()
+	carol := NewWallet()
+	utxoSet := NewUTXOSet()
+	genesis := &Block{Transactions: []*Transaction{NewTransaction("", alice.Address(), 100)}}
+	if _, err := utxoSet.ApplyBlock(genesis, 0); err != nil {
+		t.Fatalf("ApplyBlock() failed with error: %v", err)
+	}
+
+	// The outputs plus the fee wrap around to exactly the value of the input
+	tx := NewTransaction(alice.Address(), bob.Address(), 1)
+	tx.Fee = 101
+	tx.Inputs = []TxInput{{TxID: genesis.Transactions[0].ID, Vout: 0}}
+	tx.Outputs = []TxOutput{{Address: bob.Address(), Amount: 1}, {Address: carol.Address(), Amount: math.MaxInt}, {Address: carol.Address(), Amount: math.MaxInt}}
+	if err := tx.Sign(alice.PrivateKey); err != nil {
+		t.Fatalf("Sign() failed with error: %v", err)
+	}
+	if tx.IsValid() {
+		t.Error("IsValid() failed, outputs above MaxMoney should be invalid")
+	}
+	if _, err := utxoSet.ApplyBlock(&Block{Transactions: []*Transaction{tx}}, 1); err == nil {
+		t.Error("ApplyBlock() failed, expected the overflowing outputs to be rejected")
+	}
+	if utxoSet.Balance(alice.Address()) != 100 || utxoSet.Balance(carol.Address()) != 0 {
+		t.Errorf("ApplyBlock() failed, the set changed: alice has %d, carol has %d",
+			utxoSet.Balance(alice.Address()), utxoSet.Balance(carol.Address()))
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/rpc"
+	"strings"
+	"time"
+)
+
+// Names of the block validation rules reported in BlockValidationError.
+const (
+	RuleDifficulty   = "difficulty"   // Difficulty out of bounds or not what the retarget rule demands
+	RuleHash         = "hash"         // Hash does not match the block header
+	RuleMerkleRoot   = "merkle-root"  // Merkle root does not match the transactions
+	RuleProofOfWork  = "pow"          // Hash is not below the difficulty target
+	RuleSize         = "size"         // Too many transactions or too many bytes
+	RulePrevHash     = "prev-hash"    // Block does not point to its parent
+	RuleTimestamp    = "timestamp"    // Timestamp before the parent or too far in the future
+	RuleTransactions = "transactions" // A transaction is unsigned, replayed or overspends
+)
+
+const maxFutureBlockTime = 2 * time.Minute // How far ahead of the local clock a block may be
+
+const blockValidationErrorPrefix = "invalid block: "
+
+// BlockValidationError describes which validation rule a block failed.
+type BlockValidationError struct {
+	Rule   string // One of the Rule* constants
+	Reason string // Human-readable details
+}
+
+func (e *BlockValidationError) Error() string {
+	return blockValidationErrorPrefix + e.Rule + ": " + e.Reason
+}
+
+func invalidBlock(rule, format string, args ...interface{}) *BlockValidationError {
+	return &BlockValidationError{Rule: rule, Reason: fmt.Sprintf(format, args...)}
+}
+
+// AsBlockValidationError extracts a BlockValidationError from err. Errors returned by a
+// remote node arrive as rpc.ServerError strings and are parsed back into the typed form.
+func AsBlockValidationError(err error) *BlockValidationError {
+	var validationErr *BlockValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr
+	}
+
+	var serverErr rpc.ServerError
+	if errors.As(err, &serverErr) && strings.HasPrefix(string(serverErr), blockValidationErrorPrefix) {
+		rule, reason, _ := strings.Cut(strings.TrimPrefix(string(serverErr), blockValidationErrorPrefix), ": ")
+		return &BlockValidationError{Rule: rule, Reason: reason}
+	}
+	return nil
+}
+
+// target returns the value the block hash must stay below for the block's difficulty.
+func (b *Block) target() *big.Int {
+	target := big.NewInt(1)
+	return target.Lsh(target, uint(256-b.Difficulty))
+}
+
+// Validate runs the checks that need nothing but the block and its parent: the header
+// is valid and the transactions match the header's Merkle root within the size limits.
+// prev may be nil when the parent is unknown.
+func (b *Block) Validate(prev *Block) error {
+	if err := b.ValidateHeader(prev); err != nil {
+		return err
+	}
+	return b.validateBody()
+}
+
+// validateBody checks the transactions against the size limits and the Merkle root.
+func (b *Block) validateBody() error {
+	if len(b.Transactions) > MaxTransactionsPerBlock {
+		return invalidBlock(RuleSize, "%d transactions, at most %d allowed", len(b.Transactions), MaxTransactionsPerBlock)
+	}
+	if size := b.Size(); size > MaxBlockSize {
+		return invalidBlock(RuleSize, "%d bytes, at most %d allowed", size, MaxBlockSize)
+	}
+	for _, tx := range b.Transactions {
+		if !bytes.Equal(tx.ID, tx.Hash()) {
+			return invalidBlock(RuleMerkleRoot, "transaction %x does not match its ID", tx.ID)
+		}
+	}
+	if !bytes.Equal(b.MerkleRoot, b.HashTransactions()) {
+		return invalidBlock(RuleMerkleRoot, "merkle root %x does not match the transactions", b.MerkleRoot)
+	}
+	return nil
+}
+
+// ValidateHeader runs the checks that only need the block header: the hash matches the
+// header, the hash satisfies the proof of work and the timestamp is sane. The
+// transactions are not looked at, so headers can be checked before their blocks are
+// downloaded. prev may be nil when the parent is unknown.
+func (b *Block) ValidateHeader(prev *Block) error {
+	if b.Difficulty < minDifficulty || b.Difficulty > maxDifficulty {
+		return invalidBlock(RuleDifficulty, "difficulty %d outside [%d, %d]", b.Difficulty, minDifficulty, maxDifficulty)
+	}
+	if !bytes.Equal(b.Hash, b.ComputeHash()) {
+		return invalidBlock(RuleHash, "hash %x does not match the block header", b.Hash)
+	}
+
+	var hashInt big.Int
+	hashInt.SetBytes(b.Hash)
+	if hashInt.Cmp(b.target()) >= 0 {
+		return invalidBlock(RuleProofOfWork, "hash %x does not meet difficulty %d", b.Hash, b.Difficulty)
+	}
+
+	if maxTime := time.Now().Add(maxFutureBlockTime).Unix(); b.Timestamp > maxTime {
+		return invalidBlock(RuleTimestamp, "timestamp %d is more than %v in the future", b.Timestamp, maxFutureBlockTime)
+	}
+
+	if prev != nil {
+		if !bytes.Equal(b.PrevBlockHash, prev.Hash) {
+			return invalidBlock(RulePrevHash, "previous hash %x does not match parent %x", b.PrevBlockHash, prev.Hash)
+		}
+		if b.Timestamp < prev.Timestamp {
+			return invalidBlock(RuleTimestamp, "timestamp %d is before parent timestamp %d", b.Timestamp, prev.Timestamp)
+		}
+	}
+	return nil
+}
+
+// checkHeader validates a block header against the chain it extends, including the
+// difficulty the retarget rule demands. Only the headers of chain are used.
+func checkHeader(header *Block, chain []*Block) error {
+	var prev *Block
+	if len(chain) > 0 {
+		prev = chain[len(chain)-1]
+	}
+	if err := header.ValidateHeader(prev); err != nil {
+		return err
+	}
+
+	if len(chain) > 0 {
+		if required := requiredDifficulty(chain); header.Difficulty != required {
+			return invalidBlock(RuleDifficulty, "difficulty %d, expected %d", header.Difficulty, required)
+		}
+	}
+	return nil
+}
+
+// checkBlock validates a block against the chain it extends, including the
+// difficulty the retarget rule demands and the signatures of its transactions.
+func checkBlock(block *Block, chain []*Block) error {
+	if err := checkHeader(block, chain); err != nil {
+		return err
+	}
+	if err := block.validateBody(); err != nil {
+		return err
+	}
+	if !block.HasValidTransactions() {
+		return invalidBlock(RuleTransactions, "block contains an invalid transaction signature")
+	}
+	return nil
+}
@@ -2,37 +2,63 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
-	"net/rpc"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 var templates = template.Must(template.ParseGlob("templates/*.html"))
 
-// Global variable to store pending transactions.
-var pendingTransactions []*Transaction
+const sessionCookie = "session" // Cookie holding the token of the session a login unlocked a wallet for
 
 type Application struct {
 	Blockchain   *Blockchain
-	PollInterval int // Polling interval in seconds
+	PollInterval int          // Polling interval in seconds
+	Keystore     *Keystore    // Encrypted private keys, unlocked while their owner is logged in
+	Peers        *PeerManager // Nodes transactions are broadcast to, discovered from the seeds
+	Conns        *ConnManager // Open connections to the nodes
+	Tracker      *TxTracker   // Status of the transactions sent from this wallet
+
+	chainMutex sync.Mutex // Held while Blockchain is read or updated
 }
 
-// NewApplication creates a new application instance.
-func NewApplication() *Application {
+// NewApplication creates a new application instance talking to the nodes over transport.
+func NewApplication(seeds []string, transport *Transport) *Application {
 	app := &Application{
-		Blockchain:   NewBlockchain(), // Initial load
-		PollInterval: 3,               // For example, poll every 3 seconds
-	}
+		Blockchain:   NewBlockchain(""), // Initial load
+		PollInterval: 3,                 // For example, poll every 3 seconds
+		Keystore:     NewKeystore(keystoreDir),
+		Peers:        NewPeerManager("", "", seeds),
+		Tracker:      NewTxTracker(),
+	}
+	app.Peers.LocalHandshake = func() Handshake {
+		app.chainMutex.Lock()
+		defer app.chainMutex.Unlock()
+		return app.Blockchain.Handshake("", nil)
+	}
+	app.Peers.Transport = transport
+	app.Conns = NewConnManager(app.Peers)
 	go app.startBlockchainUpdate()
+	go app.Conns.Run(peerDiscoveryInterval)
+	go watchEvents(app.Conns, EventFilter{Topics: []string{EventBlock, EventReorg, EventTx}}, func(event Event) {
+		if event.Type == EventTx {
+			if _, tracked := app.Tracker.Status(hex.EncodeToString(event.TxID)); tracked {
+				app.refreshTxStatus()
+			}
+			return
+		}
+		app.syncWithNodes()
+		app.refreshTxStatus()
+	})
 	return app
 }
 
@@ -41,7 +67,9 @@ type BlockForTemplate struct {
 	Transactions  []*TransactionForTemplate // Or any transaction info you want to display in the template
 	PrevBlockHash string                    // Base64 encoded
 	Hash          string                    // Base64 encoded
+	MerkleRoot    string                    // Hex encoded
 	Nonce         int
+	Difficulty    int
 }
 
 type TransactionForTemplate struct {
@@ -49,6 +77,7 @@ type TransactionForTemplate struct {
 	From   string // Sender's address
 	To     string // Receiver's address
 	Amount int    // Transaction amount
+	Fee    int    // Fee paid to the miner
 }
 
 func (app *Application) start(port string) {
@@ -66,6 +95,8 @@ func (app *Application) start(port string) {
 	http.HandleFunc("/login", app.handleLogin)
 	http.HandleFunc("/logout", app.handleLogout)
 	http.HandleFunc("/transaction-history", app.handleTransactionHistory)
+	http.HandleFunc("/merkle-proof", app.handleMerkleProof)
+	http.HandleFunc("/tx/", app.handleTxStatus)
 
 	address := "127.0.0.1:" + port
 	log.Printf("Wallet server started on http://127.0.0.1:%s\n", port)
@@ -132,18 +163,39 @@ func (app *Application) handleNewTransaction(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
-		tx := NewTransaction(from, to, amount)
+		// 手续费可选，默认为 0
+		fee := 0
+		if r.FormValue("fee") != "" {
+			fee, err = strconv.Atoi(r.FormValue("fee"))
+			if err != nil || fee < 0 {
+				http.Error(w, "Invalid fee", http.StatusBadRequest)
+				return
+			}
+		}
 
-		// 将交易添加到挂起列表
-		pendingTransactions = append(pendingTransactions, tx)
+		// 只用本次登录会话解锁的私钥签名
+		wallet, ok := app.sessionWallet(r)
+		if !ok || wallet.Address() != from {
+			http.Error(w, "No signing key available for this address", http.StatusForbidden)
+			return
+		}
+
+		tx, err := NewSignedTransactionWithFee(wallet, to, amount, fee, app.nextNonce(from))
+		if err != nil {
+			http.Error(w, "Unable to sign transaction", http.StatusInternalServerError)
+			return
+		}
+
+		// 跟踪交易状态，直到被打包或丢弃
+		app.Tracker.Track(tx)
 
 		// 广播交易到所有已知节点
-		BroadcastTransactionToNodes(tx)
+		BroadcastTransactionToNodes(app.Conns, tx)
 
 		// app.Blockchain.AddTransactionToMempool(tx)
 		// app.Blockchain.MineBlock()
 
-		http.Redirect(w, r, "/mywallet", http.StatusSeeOther)
+		http.Redirect(w, r, "/tx/"+hex.EncodeToString(tx.ID), http.StatusSeeOther)
 	} else {
 
 		err := templates.ExecuteTemplate(w, "transaction_form.html", data)
@@ -162,7 +214,7 @@ func (app *Application) handleViewBlockchain(w http.ResponseWriter, r *http.Requ
 		username = usernameCookie.Value
 	}
 
-	preparedBlocks := prepareBlocksForTemplate(app.Blockchain.Blocks)
+	preparedBlocks := prepareBlocksForTemplate(app.blocks())
 
 	data := struct {
 		Username string
@@ -194,6 +246,12 @@ func (app *Application) handleRegister(w http.ResponseWriter, r *http.Request) {
 		wallet := NewWallet()
 		address := wallet.Address()
 
+		if err := app.Keystore.Store(wallet, password); err != nil {
+			log.Printf("Error storing key for %s: %v", username, err)
+			http.Error(w, "Unable to register user", http.StatusInternalServerError)
+			return
+		}
+
 		file, err := os.OpenFile("users.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			http.Error(w, "Unable to register user", http.StatusInternalServerError)
@@ -236,7 +294,23 @@ func (app *Application) handleLogin(w http.ResponseWriter, r *http.Request) {
 		for scanner.Scan() {
 			line := scanner.Text()
 			parts := strings.Split(line, ":")
-			if parts[0] == username && parts[1] == password {
+			if len(parts) == 3 && parts[0] == username && parts[1] == password {
+
+				// 解锁钱包私钥，用于签名交易，只能凭会话令牌取用
+				if wallet, err := app.Keystore.Unlock(parts[2], password); err != nil {
+					log.Printf("Unable to unlock wallet of %s: %v", username, err)
+				} else if token, err := app.Keystore.StartSession(wallet); err != nil {
+					log.Printf("Unable to start a session for %s: %v", username, err)
+				} else {
+					http.SetCookie(w, &http.Cookie{
+						Name:     sessionCookie,
+						Value:    token,
+						Path:     "/",
+						MaxAge:   3600,
+						HttpOnly: true,
+						SameSite: http.SameSiteStrictMode,
+					})
+				}
 
 				// 登录成功，设置cookie
 				http.SetCookie(w, &http.Cookie{
@@ -268,6 +342,11 @@ func (app *Application) handleLogin(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) handleLogout(w http.ResponseWriter, r *http.Request) {
+	// 锁定钱包私钥
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		app.Keystore.EndSession(cookie.Value)
+	}
+
 	// 设置cookie过期
 	http.SetCookie(w, &http.Cookie{
 		Name:   "loggedin",
@@ -283,6 +362,13 @@ func (app *Application) handleLogout(w http.ResponseWriter, r *http.Request) {
 		MaxAge: -1,
 	})
 
+	http.SetCookie(w, &http.Cookie{
+		Name:   sessionCookie,
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
+
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
@@ -315,6 +401,16 @@ func (app *Application) handleMyWallet(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// sessionWallet returns the wallet unlocked by the login of the request's session.
+// Unlike the username cookie, the session token cannot be guessed from public data.
+func (app *Application) sessionWallet(r *http.Request) (*Wallet, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, false
+	}
+	return app.Keystore.Session(cookie.Value)
+}
+
 // getWalletInfo retrieves wallet information based on the username.
 func (app *Application) getWalletInfo(username string) (string, int, []Transaction) {
 	file, err := os.Open("users.txt")
@@ -329,7 +425,9 @@ func (app *Application) getWalletInfo(username string) (string, int, []Transacti
 		if len(parts) == 3 && parts[0] == username {
 			address := parts[2]
 
+			app.chainMutex.Lock()
 			balance := BalanceOf(app.Blockchain, address)
+			app.chainMutex.Unlock()
 			transactions := []Transaction{}
 			return address, balance, transactions
 		}
@@ -355,7 +453,9 @@ func prepareBlocksForTemplate(blocks []*Block) []*BlockForTemplate {
 			Transactions:  preparedTransactions,
 			PrevBlockHash: encodedPrevHash,
 			Hash:          encodedHash,
+			MerkleRoot:    hex.EncodeToString(block.MerkleRoot),
 			Nonce:         block.Nonce,
+			Difficulty:    block.Difficulty,
 		}
 
 		blocksForTemplate = append(blocksForTemplate, newBlock)
@@ -371,50 +471,43 @@ func prepareTransactionsForTemplate(transactions []*Transaction) []*TransactionF
 			From:   tx.From,
 			To:     tx.To,
 			Amount: tx.Amount,
+			Fee:    tx.Fee,
 		}
 		transactionsForTemplate = append(transactionsForTemplate, txForTemplate)
 	}
 	return transactionsForTemplate
 }
 
-// ReceiveTransactionConfirmation receives a transaction confirmation.
-func ReceiveTransactionConfirmation(txID []byte) {
-	for i, tx := range pendingTransactions {
-		if bytes.Equal(tx.ID, txID) {
-			// 移除已确认的交易
-			pendingTransactions = append(pendingTransactions[:i], pendingTransactions[i+1:]...)
-			break
+// nextNonce returns the nonce for the next transaction from address, counting
+// transactions this wallet has sent that are not yet in its copy of the chain.
+func (app *Application) nextNonce(address string) uint64 {
+	app.chainMutex.Lock()
+	nonce := app.Blockchain.NextNonce(address)
+	app.chainMutex.Unlock()
+	for _, tx := range app.Tracker.Unconfirmed(address) {
+		if tx.From == address && tx.Nonce >= nonce {
+			nonce = tx.Nonce + 1
 		}
 	}
+	return nonce
 }
 
-// BroadcastTransactionToNodes broadcasts a transaction to all known nodes.
-func BroadcastTransactionToNodes(tx *Transaction) {
-	nodes, err := os.ReadFile("nodes.txt")
-	if err != nil {
-		log.Printf("Error reading nodes.txt: %v", err)
-		return
-	}
-
-	for _, node := range strings.Split(string(nodes), "\n") {
-		if node != "" {
-			go func(node string) {
-				client, err := rpc.Dial("tcp", node)
-				if err != nil {
-					log.Printf("Error dialing node %s: %v", node, err)
-					return
-				}
-				defer client.Close()
-
-				var reply string
-				err = client.Call("Node.ReceiveTransaction", tx, &reply)
-				if err != nil {
-					log.Printf("Error broadcasting transaction to node %s: %v", node, err)
-				} else {
-					log.Printf("Broadcasted transaction to node %s: %s", node, reply)
-				}
-			}(node)
+// BroadcastTransactionToNodes broadcasts a transaction to the outbound peers. Nodes
+// that could not be reached recently are skipped.
+func BroadcastTransactionToNodes(conns *ConnManager, tx *Transaction) {
+	for _, node := range conns.Peers.Outbound() {
+		if conns.State(node) == ConnBackoff {
+			continue
 		}
+		go func(node string) {
+			var reply string
+			err := conns.Call(node, "Node.ReceiveTransaction", tx, &reply, CapabilityMempool)
+			if err != nil {
+				log.Printf("Error broadcasting transaction to node %s: %v", node, err)
+			} else {
+				log.Printf("Broadcasted transaction to node %s: %s", node, reply)
+			}
+		}(node)
 	}
 }
 
@@ -425,6 +518,7 @@ func (app *Application) startBlockchainUpdate() {
 		select {
 		case <-ticker.C:
 			app.updateBlockchainFromConsensus()
+			app.refreshTxStatus()
 		}
 	}
 }
@@ -448,19 +542,34 @@ func (app *Application) updateBlockchainFromConsensus() {
 	}
 
 	if len(blocks) > 0 {
-		app.Blockchain.Blocks = blocks
-		log.Println("Blockchain updated from consensus file")
+		app.chainMutex.Lock()
+		updated, err := app.Blockchain.Reorganize(blocks)
+		app.chainMutex.Unlock()
+		if err != nil {
+			log.Printf("Error applying consensus blockchain: %v", err)
+			return
+		}
+		if updated {
+			log.Println("Blockchain updated from consensus file")
+		}
 	}
 }
 
-// transactionExistsInHistory checks if a transaction already exists in the transaction history.
-func transactionExistsInHistory(tx *Transaction, history []*Transaction) bool {
-	for _, htx := range history {
-		if bytes.Equal(tx.ID, htx.ID) {
-			return true
-		}
+// syncWithNodes downloads the blocks the nodes have and we lack, without waiting for the
+// consensus monitor to save them. Called when a node reports a new block.
+func (app *Application) syncWithNodes() {
+	syncer := &chainSync{conns: app.Conns, mutex: &app.chainMutex, chain: app.Blockchain}
+	if syncer.Run() {
+		log.Println("Blockchain updated from the nodes")
 	}
-	return false
+}
+
+// blocks returns a copy of the chain, which stays valid while Blockchain is updated.
+func (app *Application) blocks() []*Block {
+	app.chainMutex.Lock()
+	defer app.chainMutex.Unlock()
+
+	return append([]*Block(nil), app.Blockchain.Blocks...)
 }
 
 // handleTransactionHistory handles the request for the transaction history page.
@@ -474,17 +583,25 @@ func (app *Application) handleTransactionHistory(w http.ResponseWriter, r *http.
 
 	address, _, _ := app.getWalletInfo(username)
 
-	transactions := []*Transaction{}
-	for _, block := range app.Blockchain.Blocks {
+	// Transactions sent from this wallet first, with their status, then the rest of the chain's
+	transactions := app.Tracker.Statuses(address)
+	listed := make(map[string]bool)
+	for _, status := range transactions {
+		listed[status.ID] = true
+	}
+	blocks := app.blocks()
+	for height, block := range blocks {
 		for _, tx := range block.Transactions {
-			if (tx.From == address || tx.To == address) && !transactionExistsInHistory(tx, transactions) {
-				transactions = append(transactions, tx)
+			id := hex.EncodeToString(tx.ID)
+			if (tx.From == address || tx.To == address) && !listed[id] {
+				transactions = append(transactions, includedStatus(blocks, height, tx))
+				listed[id] = true
 			}
 		}
 	}
 	data := struct {
 		Username     string
-		Transactions []*Transaction
+		Transactions []TxStatus
 	}{
 		Username:     username,
 		Transactions: transactions,
@@ -494,3 +611,116 @@ func (app *Application) handleTransactionHistory(w http.ResponseWriter, r *http.
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// handleTxStatus shows the status of the transaction at /tx/<hex id>, as JSON with
+// ?format=json.
+func (app *Application) handleTxStatus(w http.ResponseWriter, r *http.Request) {
+	id := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/tx/"))
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+
+	status, ok := app.Tracker.Status(id)
+	if !ok {
+		// Not sent from this wallet, the chain may still know it
+		app.chainMutex.Lock()
+		status, ok = chainTxStatus(app.Blockchain.Blocks, id)
+		app.chainMutex.Unlock()
+	}
+	if !ok {
+		http.Error(w, ErrTransactionNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Printf("Failed to encode transaction status: %v", err)
+		}
+		return
+	}
+
+	var username string
+	if usernameCookie, err := r.Cookie("username"); err == nil {
+		username = usernameCookie.Value
+	}
+	data := struct {
+		Username string
+		Status   TxStatus
+		Refresh  bool // Reload the page while the status may change
+	}{
+		Username: username,
+		Status:   status,
+		Refresh:  !status.final(),
+	}
+	if err := templates.ExecuteTemplate(w, "tx_status.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// refreshTxStatus asks the nodes which of the tracked transactions they hold in their
+// mempool and updates the status of every tracked transaction.
+func (app *Application) refreshTxStatus() {
+	open := app.Tracker.Open()
+	if len(open) == 0 {
+		return
+	}
+	if len(open) > maxInvItems {
+		open = open[:maxInvItems]
+	}
+	items := make([]InvItem, len(open))
+	for i, tx := range open {
+		items[i] = InvItem{Type: InvTx, Hash: tx.ID}
+	}
+
+	mempools := make(map[string][]string)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range app.Peers.Outbound() {
+		if app.Conns.State(node) == ConnBackoff {
+			continue
+		}
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			var reply GetDataReply
+			if err := app.Conns.Call(node, "Node.GetData", items, &reply, CapabilityMempool); err != nil {
+				log.Printf("Error checking the mempool of node %s: %v", node, err)
+				return
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			for _, tx := range reply.Transactions {
+				id := hex.EncodeToString(tx.ID)
+				mempools[id] = append(mempools[id], node)
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	blocks := app.blocks()
+	app.Tracker.Update(blocks, mempools)
+}
+
+// handleMerkleProof returns a JSON inclusion proof for the transaction given as ?tx=<hex id>.
+func (app *Application) handleMerkleProof(w http.ResponseWriter, r *http.Request) {
+	txID, err := hex.DecodeString(r.URL.Query().Get("tx"))
+	if err != nil || len(txID) == 0 {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+
+	app.chainMutex.Lock()
+	proof, err := app.Blockchain.FindMerkleProof(txID)
+	app.chainMutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(proof); err != nil {
+		log.Printf("Failed to encode merkle proof: %v", err)
+	}
+}
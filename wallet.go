@@ -8,6 +8,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log"
+	"math/big"
 	mrand "math/rand" // Using alias to avoid conflict
 	"time"
 )
@@ -35,8 +36,27 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-	return *private, pubKey
+	return *private, pubKeyBytes(&private.PublicKey)
+}
+
+// pubKeyBytes encodes a public key as the fixed-size concatenation of its X and Y coordinates.
+func pubKeyBytes(pub *ecdsa.PublicKey) []byte {
+	pubKey := make([]byte, 64)
+	pub.X.FillBytes(pubKey[:32])
+	pub.Y.FillBytes(pubKey[32:])
+	return pubKey
+}
+
+// parsePubKey restores an ecdsa.PublicKey from the encoding produced by pubKeyBytes.
+func parsePubKey(pubKey []byte) (*ecdsa.PublicKey, error) {
+	if len(pubKey) != 64 {
+		return nil, fmt.Errorf("invalid public key length %d", len(pubKey))
+	}
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(pubKey[:32]),
+		Y:     new(big.Int).SetBytes(pubKey[32:]),
+	}, nil
 }
 
 // HashPubKey hashes the public key.
@@ -54,7 +74,12 @@ func Checksum(payload []byte) []byte {
 
 // Address generates a wallet address.
 func (w Wallet) Address() string {
-	pubKeyHash := HashPubKey(w.PublicKey)
+	return AddressFromPubKey(w.PublicKey)
+}
+
+// AddressFromPubKey derives the wallet address that belongs to a public key.
+func AddressFromPubKey(pubKey []byte) string {
+	pubKeyHash := HashPubKey(pubKey)
 	versionedPayload := append([]byte{version}, pubKeyHash...)
 	checksum := Checksum(versionedPayload)
 	fullPayload := append(versionedPayload, checksum...)